@@ -251,7 +251,7 @@ ibc.load(options, function (err, cc){														//parse/load chaincode, respo
 
 		// ---- To Deploy or Not to Deploy ---- //
 		if(!cc.details.deployed_name || cc.details.deployed_name === ''){					//yes, go deploy
-			cc.deploy('init', ['99'], {delay_ms: 30000}, function(e){ 						//delay_ms is milliseconds to wait after deploy for conatiner to start, 50sec recommended
+			cc.deploy('init', [JSON.stringify({abc: 99})], {delay_ms: 30000}, function(e){ 						//delay_ms is milliseconds to wait after deploy for conatiner to start, 50sec recommended
 				check_if_deployed(e, 1);
 			});
 		}
@@ -312,9 +312,42 @@ function cb_deployed(e){
 		console.log('! looks like a deploy error, holding off on the starting the socket\n', e);
 		if(!process.error) process.error = {type: 'deploy', msg: e.details};
 	}
+	else check_identity(cb_identified);
+}
+
+//the chaincode only lets callers it can identify invoke, it reads the "username" and "role" attributes of the enrollId's certificate
+//marbles creates marbles for every user, so its enrollId needs the operator role. see docs/use_local_hyperledger.md
+function check_identity(cb){
+	chaincode.query.whoami([], function(err, resp){
+		var caller = null;
+		try{
+			if(err == null) caller = JSON.parse(resp);
+		}
+		catch(e){}																			//not json, an older chaincode
+		
+		if(!caller || !caller.id) cb('the enrollId has no "username" attribute on its certificate, the chaincode will reject every invoke');
+		else if(caller.roles.indexOf('operator') === -1) cb('enrollId user "' + caller.id + '" does not have the "operator" role, the chaincode will reject most invokes');
+		else{
+			console.log('[preflight check] invoking as', caller.id, 'with roles', caller.roles.join(','));
+			cb(null);
+		}
+	});
+}
+
+function cb_identified(e){
+	if(e != null){
+		console.log('\n[!] ' + e + '\n[!] see docs/use_local_hyperledger.md for the attributes marbles needs, holding off on starting the socket\n');
+		if(!process.error) process.error = {type: 'identity', msg: e};
+	}
 	else{
 		console.log('------------------------------------------ Websocket Up ------------------------------------------');
 		
+		[setup.USER1, setup.USER2].forEach(function(username){								//marbles can only be owned by registered users
+			chaincode.invoke.register_user([username], function(e){
+				if(e != null) console.log('register user', username, '- already registered or failed:', e);
+			});
+		});
+		
 		wss = new ws.Server({server: server});												//start the websocket now
 		wss.on('connection', function connection(ws) {
 			ws.on('message', function incoming(message) {
@@ -352,7 +385,7 @@ function cb_deployed(e){
 				console.log('hey new block, lets refresh and broadcast to all', chain_stats.height-1);
 				ibc.block_stats(chain_stats.height - 1, cb_blockstats);
 				wss.broadcast({msg: 'reset'});
-				chaincode.query.read(['_marbleindex'], cb_got_index);
+				chaincode.query.list_marbles(['', ''], cb_got_page);
 				chaincode.query.read(['_opentrades'], cb_got_trades);
 			}
 			
@@ -366,32 +399,19 @@ function cb_deployed(e){
 				}
 			}
 			
-			//got the marble index, lets get each marble
-			function cb_got_index(e, index){
-				if(e != null) console.log('marble index error:', e);
+			//got a page of marbles, send each one and ask for the next page
+			function cb_got_page(e, page){
+				if(e != null) console.log('marbles error:', e);
 				else{
 					try{
-						var json = JSON.parse(index);
-						for(var i in json){
-							console.log('!', i, json[i]);
-							chaincode.query.read([json[i]], cb_got_marble);					//iter over each, read their values
+						var json = JSON.parse(page);
+						for(var i in json.marbles){
+							wss.broadcast({msg: 'marbles', marble: json.marbles[i]});
 						}
+						if(json.bookmark) chaincode.query.list_marbles(['', json.bookmark], cb_got_page);		//more to come
 					}
 					catch(e){
-						console.log('marbles index msg error:', e);
-					}
-				}
-			}
-			
-			//call back for getting a marble, lets send a message
-			function cb_got_marble(e, marble){
-				if(e != null) console.log('marble error:', e);
-				else {
-					try{
-						wss.broadcast({msg: 'marbles', marble: JSON.parse(marble)});
-					}
-					catch(e){
-						console.log('marble msg error', e);
+						console.log('marbles msg error:', e);
 					}
 				}
 			}
@@ -0,0 +1,228 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Certificate attributes the caller's identity is read from. They are issued
+// by the attribute certificate authority, so the caller can not forge them.
+const (
+	attrUsername = "username" //marble user name or driver email of the caller
+	attrRole     = "role"     //comma separated roles, see below
+)
+
+// Roles a caller can hold on top of being a plain user
+const (
+	roleOperator    = "operator"     //runs the chaincode: init, migrations, repairs
+	roleRentalAdmin = "rental_admin" //manages drivers and bookings
+)
+
+// Caller is the identity invoking the chaincode
+type Caller struct {
+	Id    string   `json:"id"`    //lower case username attribute, empty if the certificate has none
+	Roles []string `json:"roles"` //roles from the role attribute
+}
+
+// ============================================================================================================================
+// getCaller - resolve the caller's identity from their certificate attributes
+// ============================================================================================================================
+func getCaller(stub shim.ChaincodeStubInterface) Caller {
+	var caller Caller
+	if id, err := stub.ReadCertAttribute(attrUsername); err == nil {
+		caller.Id = strings.ToLower(strings.TrimSpace(string(id)))
+	}
+	if roles, err := stub.ReadCertAttribute(attrRole); err == nil {
+		for _, role := range strings.Split(string(roles), ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				caller.Roles = append(caller.Roles, role)
+			}
+		}
+	}
+	return caller
+}
+
+// ============================================================================================================================
+// Who Am I - query, the identity the chaincode reads from the caller's certificate, so a client can check its enrollment
+// carries the username and role attributes before it invokes anything
+// ============================================================================================================================
+func (t *SimpleChaincode) whoami(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	caller := getCaller(stub)
+	if caller.Roles == nil {
+		caller.Roles = []string{}
+	}
+	return json.Marshal(caller)
+}
+
+// Is - true if the caller holds role
+func (c Caller) Is(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUser - true if the caller is the user or driver with this id
+func (c Caller) IsUser(id string) bool {
+	return c.Id != "" && c.Id == strings.ToLower(id)
+}
+
+// policy decides if a caller may run a function with these args, returning why not
+type policy func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error
+
+// ============================================================================================================================
+// Policies - who may run each Invoke function. A function without a policy can not be invoked.
+// ============================================================================================================================
+var invokePolicies = map[string]policy{
+	"reset_ledger":      operatorOnly,
+	"write":             operatorOnly,
+	"repair_records":    operatorOnly,
+	"migrate_passwords": operatorOnly,
+	"migrate_keys":      operatorOnly,
+	"rebuild_indexes":   operatorOnly,
+	"register_owners":   operatorOnly,
+	"deactivate_user":   operatorOnly,
+	"purge_marble":      operatorOnly,
+	"register_user":     userArg(0),
+	"delete":            marbleOwner(0),
+	"delete_marble":     marbleOwner(0),
+	"set_user":          marbleOwner(0),
+	"restore_marble":    marbleOwner(0),
+	"init_marble":       userArg(3),
+	"open_trade":        userArg(0),
+	"perform_trade":     userArg(1),
+	"remove_trade":      tradeOwner(0),
+	"signup_driver":     driverSelf(3),
+	"set_status":        driverSelf(0),
+	"book_car":          driverSelf(1),
+	"delete_driver":     rentalAdminOnly,
+	"cancel_booking":    driverSelf(0),
+	"confirm_booking":   rentalAdminOnly,
+	"pickup_booking":    rentalAdminOnly,
+	"return_booking":    rentalAdminOnly,
+	"no_show_booking":   rentalAdminOnly,
+	"update_driver":     driverSelf(0),
+	"review_driver":     rentalAdminOnly,
+	"approve_driver":    rentalAdminOnly,
+	"reject_driver":     rentalAdminOnly,
+	"suspend_driver":    rentalAdminOnly,
+	"reinstate_driver":  rentalAdminOnly,
+}
+
+// ============================================================================================================================
+// authorize - check the caller against the function's policy before anything runs
+// ============================================================================================================================
+func authorize(stub shim.ChaincodeStubInterface, function string, args []string) error {
+	rule, ok := invokePolicies[function]
+	if !ok {
+		return newError(CodeNotAllowed, "No access policy for "+function)
+	}
+	return rule(stub, getCaller(stub), args)
+}
+
+func notAllowed(caller Caller, what string, arg int) error {
+	who := caller.Id
+	if who == "" {
+		return &ChaincodeError{Code: CodeUnauthenticated, Message: "Caller certificate has no " + attrUsername + " attribute", Arg: NoArg}
+	}
+	return &ChaincodeError{Code: CodeNotAllowed, Message: who + " " + what, Arg: arg}
+}
+
+// operatorOnly - only the chaincode operator
+func operatorOnly(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+	if caller.Is(roleOperator) {
+		return nil
+	}
+	return notAllowed(caller, "is not a chaincode operator", NoArg)
+}
+
+// rentalAdminOnly - only a rental admin
+func rentalAdminOnly(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+	if caller.Is(roleRentalAdmin) {
+		return nil
+	}
+	return notAllowed(caller, "is not a rental admin", NoArg)
+}
+
+// marbleOwner - the owner of the marble named by args[i], or an operator
+func marbleOwner(i int) policy {
+	return func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+		if caller.Is(roleOperator) {
+			return nil
+		}
+		marble := Marble{}
+		found, err := getRecord(stub, marbleKey(args[i]), docMarble, &marble)
+		if err != nil {
+			return err
+		}
+		if found && caller.IsUser(marble.User) {
+			return nil
+		}
+		return notAllowed(caller, "does not own marble "+args[i], i)
+	}
+}
+
+// userArg - the user named by args[i] acting for themselves, or an operator
+func userArg(i int) policy {
+	return func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+		if caller.Is(roleOperator) || caller.IsUser(args[i]) {
+			return nil
+		}
+		return notAllowed(caller, "can not act for "+args[i], i)
+	}
+}
+
+// tradeOwner - the user who opened the trade with id args[i], or an operator
+func tradeOwner(i int) policy {
+	return func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+		if caller.Is(roleOperator) {
+			return nil
+		}
+		tradesAsBytes, err := stub.GetState(openTradesStr)
+		if err != nil {
+			return ledgerError("Failed to get opentrades")
+		}
+		var trades AllTrades
+		json.Unmarshal(tradesAsBytes, &trades)
+		pos, err := findTrade(trades, args[i])
+		if err != nil {
+			return err
+		}
+		if caller.IsUser(trades.OpenTrades[pos].User) {
+			return nil
+		}
+		return notAllowed(caller, "did not open trade "+args[i], i)
+	}
+}
+
+// driverSelf - the driver whose email is args[i], or a rental admin. The status fields of a driver are
+// left alone by both, see keepStatusFields.
+func driverSelf(i int) policy {
+	return func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+		if caller.Is(roleRentalAdmin) || caller.IsUser(args[i]) {
+			return nil
+		}
+		return notAllowed(caller, "can not act for driver "+args[i], i)
+	}
+}
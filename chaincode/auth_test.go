@@ -0,0 +1,122 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+func TestAuthorize(t *testing.T) {
+	s, err := ledgertest.Scenario{
+		Name:     "setup",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob", "leroy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"bob", "red", "35", "blue", "16"}},
+			ledgertest.Step{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", "d@x.com", "1", "pw", "addr", "", "", "", "", ""}},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trade := "tx000005-0"
+	driver := []string{"d@x.com", "D", "dl", "1990-01-01", "1", "pw", "addr", statusPending, "", "", "", ""}
+
+	for _, tc := range []struct {
+		caller   string
+		roles    []string
+		function string
+		args     []string
+		code     string //empty when allowed
+	}{
+		{"", nil, "init_marble", []string{"m2", "red", "35", "bob"}, CodeUnauthenticated},
+		{"bob", nil, "init_marble", []string{"m2", "red", "35", "bob"}, ""},
+		{"bob", nil, "init_marble", []string{"m2", "red", "35", "leroy"}, CodeNotAllowed},
+		{"op", []string{roleOperator}, "init_marble", []string{"m2", "red", "35", "leroy"}, ""},
+		{"bob", nil, "set_user", []string{"m1", "leroy"}, ""},
+		{"BOB", nil, "set_user", []string{"m1", "leroy"}, ""},
+		{"leroy", nil, "set_user", []string{"m1", "leroy"}, CodeNotAllowed},
+		{"leroy", nil, "delete_marble", []string{"m1", "mine now"}, CodeNotAllowed},
+		{"leroy", nil, "open_trade", []string{"bob", "red", "35", "blue", "16"}, CodeNotAllowed},
+		{"leroy", nil, "perform_trade", []string{trade, "leroy", "m2", "bob", "blue", "16"}, ""},
+		{"leroy", nil, "perform_trade", []string{trade, "bob", "m1", "bob", "blue", "16"}, CodeNotAllowed},
+		{"bob", nil, "remove_trade", []string{trade}, ""},
+		{"leroy", nil, "remove_trade", []string{trade}, CodeNotAllowed},
+		{"bob", nil, "write", []string{"abc", "1"}, CodeNotAllowed},
+		{"bob", []string{roleRentalAdmin}, "reset_ledger", nil, CodeNotAllowed},
+		{"op", []string{roleOperator}, "reset_ledger", nil, ""},
+		{"d@x.com", nil, "update_driver", []string{"d@x.com", `{"mobile":"2"}`, "1"}, ""},
+		{"e@x.com", nil, "update_driver", []string{"d@x.com", `{"mobile":"2"}`, "1"}, CodeNotAllowed},
+		{"d@x.com", nil, "approve_driver", []string{"d@x.com"}, CodeNotAllowed},
+		{"admin@x.com", []string{roleRentalAdmin}, "approve_driver", []string{"d@x.com"}, ""},
+		{"op", []string{roleOperator}, "approve_driver", []string{"d@x.com"}, CodeNotAllowed},
+		{"d@x.com", nil, "set_status", driver, ""},
+		{"d@x.com", nil, "confirm_booking", []string{"d@x.com", "b1"}, CodeNotAllowed},
+		{"d@x.com", nil, "cancel_booking", []string{"d@x.com", "b1"}, ""},
+		{"op", []string{roleOperator, roleRentalAdmin}, "no_such_function", nil, CodeNotAllowed},
+		{"op", []string{roleOperator, roleRentalAdmin}, "", nil, CodeNotAllowed},
+	} {
+		s.SetCaller(tc.caller, tc.roles...)
+		s.Begin(tc.function, tc.args, true)
+		err := authorize(s, tc.function, tc.args)
+		s.Rollback()
+		name := tc.caller + " " + tc.function + "(" + strings.Join(tc.args, ",") + ")"
+		switch {
+		case tc.code == "" && err != nil:
+			t.Errorf("%s: denied: %s", name, err)
+		case tc.code != "" && !hasCode(err, tc.code):
+			t.Errorf("%s: got %v, want %s", name, err, tc.code)
+		}
+	}
+}
+
+// TestEveryInvokeHasAPolicy - the dispatcher and the policies list the same functions
+func TestEveryInvokeHasAPolicy(t *testing.T) {
+	cc := new(SimpleChaincode)
+	s := ledgertest.NewMemStub()
+	for function := range invokeSchemas {
+		if _, ok := invokePolicies[function]; !ok {
+			t.Errorf("%s has a schema but no policy", function)
+		}
+	}
+	for function := range invokePolicies {
+		s.Begin(function, nil, true)
+		_, err := cc.invoke(s, function, nil)
+		s.Rollback()
+		if hasCode(err, CodeUnknownFunction) {
+			t.Errorf("%s has a policy but is not dispatched", function)
+		}
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name: "whoami",
+		Steps: []ledgertest.Step{
+			{Function: "whoami", Query: true, Want: `{"id":"","roles":[]}`},
+			{Function: "whoami", Query: true, Want: `{"id":"bob","roles":["operator","rental_admin"]}`, Setup: as("Bob", roleOperator, roleRentalAdmin)},
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,446 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Bookings are stored under (driver email, booking id), so the bookings of a
+// driver are one range scan, and indexed by pickup location and pickup date
+// the same way marbles are indexed, see indexes.go. Index entries end in the
+// email and booking id. The driver record lists the ids of its bookings.
+//
+// A booking then moves through its own lifecycle. Each move has its own
+// Invoke function, which refuses any move not in bookingTransitions and
+// records who made it and when:
+//
+//	requested -> confirmed -> picked_up -> returned
+//	         \           \-> no_show
+//	          \-> cancelled <-/
+const (
+	nsBookingByLocation = "booking~location~date" //location, pickup date, email, bookingid
+	nsBookingByDate     = "booking~date"          //pickup date, email, bookingid
+)
+
+// bookingIndexes are the namespaces of every booking index
+var bookingIndexes = []string{nsBookingByLocation, nsBookingByDate}
+
+// Booking statuses
+const (
+	bookingRequested = "requested"
+	bookingConfirmed = "confirmed"
+	bookingPickedUp  = "picked_up"
+	bookingReturned  = "returned"
+	bookingCancelled = "cancelled"
+	bookingNoShow    = "no_show"
+)
+
+// bookingTransitions are the statuses each booking status can move to
+var bookingTransitions = map[string][]string{
+	bookingRequested: {bookingConfirmed, bookingCancelled},
+	bookingConfirmed: {bookingPickedUp, bookingCancelled, bookingNoShow},
+	bookingPickedUp:  {bookingReturned},
+	bookingReturned:  {},
+	bookingCancelled: {},
+	bookingNoShow:    {},
+}
+
+// BookingPage is one page of a booking listing, see MarblePage
+type BookingPage struct {
+	Bookings []Bookcar `json:"bookings"`
+	Bookmark string    `json:"bookmark"`
+}
+
+// byBookingTime sorts bookings oldest first
+type byBookingTime []Bookcar
+
+func (b byBookingTime) Len() int           { return len(b) }
+func (b byBookingTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byBookingTime) Less(i, j int) bool { return b[i].Timestamp < b[j].Timestamp }
+
+// dateKey - a date as it sorts in the indexes, 2016-12-31, or the value as given when it is not a date
+func dateKey(value string) string {
+	d, err := parseDate(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return d.Format(dateLayouts[0])
+}
+
+// clockKey - a time of day as book_car stores it, 17:30, or the value as given when it is not a time
+func clockKey(value string) string {
+	c, err := parseClock(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return c.Format(clockLayouts[0])
+}
+
+// bookingInstant - a pickup or dropoff date and time of day as one instant. The ledger has no time zone, so both are
+// read as UTC, the same as the transaction timestamp they are compared with.
+func bookingInstant(date string, clock string) (time.Time, error) {
+	d, err := parseDate(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	c, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(d.Year(), d.Month(), d.Day(), c.Hour(), c.Minute(), 0, 0, time.UTC), nil
+}
+
+// toMillis - an instant in milliseconds since the epoch, the unit of every timestamp on the ledger
+func toMillis(instant time.Time) int64 {
+	return instant.UnixNano() / int64(time.Millisecond)
+}
+
+// bookingIndexKeys - every index key of a booking
+func bookingIndexKeys(booking Bookcar) []string {
+	email := strings.ToLower(booking.Bookacaremail)
+	pickup := dateKey(booking.Bookacarpickupdate)
+	return []string{
+		makeKey(nsBookingByLocation, strings.ToLower(strings.TrimSpace(booking.Bookacarlocation)), pickup, email, booking.Bookingid),
+		makeKey(nsBookingByDate, pickup, email, booking.Bookingid),
+	}
+}
+
+// indexBooking - add a booking to the booking indexes
+func indexBooking(stub shim.ChaincodeStubInterface, booking Bookcar) error {
+	for _, key := range bookingIndexKeys(booking) {
+		if err := stub.PutState(key, indexValue); err != nil {
+			return ledgerError("Failed to write index " + printableKey(key))
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Get Booking - query, one booking of a driver
+// ============================================================================================================================
+func (t *SimpleChaincode) get_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "bookingid"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	booking := Bookcar{}
+	found, err := getRecord(stub, bookingKey(strings.ToLower(args[0]), args[1]), docBooking, &booking)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "Booking " + args[1] + " of " + args[0] + " does not exist", Arg: 1}
+	}
+	return json.Marshal(booking)
+}
+
+// ============================================================================================================================
+// List Bookings By Driver - query, one page of the bookings of a driver in booking id order
+// ============================================================================================================================
+func (t *SimpleChaincode) list_bookings_by_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1            2
+	// "bob@example.com", "page_size", "bookmark"     page_size and bookmark are optional
+	start := makeKey(nsBooking, strings.ToLower(args[0]))
+	return bookingPage(stub, start, start+lastKey, args, 1)
+}
+
+// ============================================================================================================================
+// List Bookings By Location - query, one page of the bookings picked up at a location, earliest pickup date first
+// ============================================================================================================================
+func (t *SimpleChaincode) list_bookings_by_location(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//       0           1            2
+	// "airport", "page_size", "bookmark"     page_size and bookmark are optional
+	start := makeKey(nsBookingByLocation, strings.ToLower(strings.TrimSpace(args[0])))
+	return bookingPage(stub, start, start+lastKey, args, 1)
+}
+
+// ============================================================================================================================
+// List Bookings By Date - query, one page of the bookings picked up from one date to another, both inclusive
+// ============================================================================================================================
+func (t *SimpleChaincode) list_bookings_by_date(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//       0              1             2            3
+	// "2016-12-01", "2016-12-31", "page_size", "bookmark"     page_size and bookmark are optional
+	from, to := dateKey(args[0]), dateKey(args[1]) //checked by the schema
+	if from > to {
+		return nil, argError(1, "The to date must not be before the from date")
+	}
+	return bookingPage(stub, makeKey(nsBookingByDate, from), makeKey(nsBookingByDate, to)+lastKey, args, 2)
+}
+
+// bookingPage - one page of the bookings whose keys lie between start and end, see indexScan. Booking keys and
+// booking index keys both end in the email and booking id.
+func bookingPage(stub shim.ChaincodeStubInterface, start string, end string, args []string, i int) ([]byte, error) {
+	page := BookingPage{Bookings: []Bookcar{}}
+	var err error
+	page.Bookmark, err = indexScan(stub, start, end, args, i, func(parts []string, full bool) (bool, error) {
+		if len(parts) < 2 {
+			return false, nil
+		}
+		booking := Bookcar{}
+		found, err := getRecord(stub, bookingKey(parts[len(parts)-2], parts[len(parts)-1]), docBooking, &booking)
+		if err != nil || !found {
+			return false, err
+		}
+		if !full {
+			page.Bookings = append(page.Bookings, booking)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(page)
+}
+
+// bookingStatus - the lifecycle status of a stored booking, bookings made before the lifecycle existed count as requested
+func bookingStatus(booking Bookcar) string {
+	status := strings.ToLower(strings.TrimSpace(booking.Status))
+	if _, known := bookingTransitions[status]; !known {
+		return bookingRequested
+	}
+	return status
+}
+
+// ============================================================================================================================
+// moveBooking - move booking args[1] of the driver with email args[0] to status to, the booking transitions all end up here
+// ============================================================================================================================
+func (t *SimpleChaincode) moveBooking(stub shim.ChaincodeStubInterface, args []string, to string, reason string) (Bookcar, error) {
+	email := strings.ToLower(args[0])
+	booking := Bookcar{}
+	found, err := getRecord(stub, bookingKey(email, args[1]), docBooking, &booking)
+	if err != nil {
+		return booking, err
+	}
+	if !found {
+		return booking, &ChaincodeError{Code: CodeNotFound, Message: "Booking " + args[1] + " of " + email + " does not exist", Arg: 1}
+	}
+	from := bookingStatus(booking)
+	if !canMove(bookingTransitions, from, to) {
+		return booking, &ChaincodeError{Code: CodeNotAllowed, Message: "Booking " + args[1] + " is " + from + " and can not become " + to, Arg: 1}
+	}
+
+	switch to {
+	case bookingPickedUp:
+		driver, err := getDriver(stub, email, 0)
+		if err != nil {
+			return booking, err
+		}
+		if status := driverStatus(driver); status != statusApproved && status != statusReinstated {
+			return booking, &ChaincodeError{Code: CodeNotAllowed, Message: "Driver " + email + " is " + status + ", only approved drivers can pick up", Arg: 0}
+		}
+	case bookingNoShow:
+		now, err := t.now(stub)
+		if err != nil {
+			return booking, err
+		}
+		pickup, err := parseDate(booking.Bookacarpickupdate) //bookings made before book_car stored the instant
+		if booking.Pickupat != 0 {
+			pickup, err = time.Unix(0, booking.Pickupat*int64(time.Millisecond)), nil
+		}
+		if err == nil && now.Before(pickup) {
+			return booking, &ChaincodeError{Code: CodeNotAllowed, Message: "Booking " + args[1] + " is not due until " + pickup.UTC().Format(time.RFC3339), Arg: 1}
+		}
+	}
+
+	timestamp, err := t.makeTimestamp(stub)
+	if err != nil {
+		return booking, err
+	}
+	booking.Status = to
+	booking.Statushistory = append(booking.Statushistory, StatusChange{From: from, To: to, By: getCaller(stub).Id, Timestamp: timestamp, Reason: reason})
+	err = putRecord(stub, bookingKey(email, args[1]), docBooking, &booking)
+	if err != nil {
+		return booking, err
+	}
+	fmt.Println("- booking " + args[1] + " moved from " + from + " to " + to)
+	return booking, nil
+}
+
+// ============================================================================================================================
+// Confirm Booking - accept a requested booking
+// ============================================================================================================================
+func (t *SimpleChaincode) confirm_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "bookingid"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	booking, err := t.moveBooking(stub, args, bookingConfirmed, "")
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventBookingStatusChanged, BookingEvent{Booking: booking})
+}
+
+// ============================================================================================================================
+// Pickup Booking - hand the car of a confirmed booking to the driver, who must be approved
+// ============================================================================================================================
+func (t *SimpleChaincode) pickup_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "bookingid"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	booking, err := t.moveBooking(stub, args, bookingPickedUp, "")
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventBookingStatusChanged, BookingEvent{Booking: booking})
+}
+
+// ============================================================================================================================
+// Return Booking - take back the car of a booking that was picked up
+// ============================================================================================================================
+func (t *SimpleChaincode) return_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "bookingid"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	booking, err := t.moveBooking(stub, args, bookingReturned, "")
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventBookingStatusChanged, BookingEvent{Booking: booking})
+}
+
+// ============================================================================================================================
+// No Show Booking - close a confirmed booking whose driver never came, not before its pickup date
+// ============================================================================================================================
+func (t *SimpleChaincode) no_show_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "bookingid"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	booking, err := t.moveBooking(stub, args, bookingNoShow, "")
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventBookingStatusChanged, BookingEvent{Booking: booking})
+}
+
+// ============================================================================================================================
+// Cancel Booking - call off a booking before the car is picked up, it stays on the ledger as cancelled
+// ============================================================================================================================
+func (t *SimpleChaincode) cancel_booking(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1           2
+	// "bob@example.com", "bookingid", "reason"     reason is optional
+	if len(args) < 2 || len(args) > 3 {
+		return nil, argCountError("2 or 3")
+	}
+	var reason string
+	if len(args) > 2 {
+		reason = args[2]
+	}
+	booking, err := t.moveBooking(stub, args, bookingCancelled, reason)
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventBookingCancelled, BookingEvent{Booking: booking})
+}
+
+// ============================================================================================================================
+// reindexBookings - index every booking, list each driver's bookings on the driver and leave only driver emails in
+// _driverindex, which book_car used to fill with booking ids. Part of rebuild_indexes, returns the bookings indexed.
+// ============================================================================================================================
+func reindexBookings(stub shim.ChaincodeStubInterface) (int, error) {
+	start, end := namespaceRange(nsBooking)
+	keysIter, err := stub.RangeQueryState(start, end)
+	if err != nil {
+		return 0, ledgerError("Failed to scan bookings")
+	}
+	var bookings []Bookcar
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return 0, ledgerError("Failed to scan bookings")
+		}
+		booking := Bookcar{}
+		if json.Unmarshal(valAsBytes, &booking) != nil {
+			fmt.Println("! skipping corrupt booking " + printableKey(key))
+			continue
+		}
+		bookings = append(bookings, booking)
+	}
+	keysIter.Close()
+
+	sort.Stable(byBookingTime(bookings))
+	byDriver := map[string][]string{}
+	for _, booking := range bookings {
+		if err := indexBooking(stub, booking); err != nil {
+			return 0, err
+		}
+		email := strings.ToLower(booking.Bookacaremail)
+		byDriver[email] = append(byDriver[email], booking.Bookingid)
+	}
+
+	start, end = namespaceRange(nsDriver)
+	keysIter, err = stub.RangeQueryState(start, end)
+	if err != nil {
+		return 0, ledgerError("Failed to scan drivers")
+	}
+	var drivers []Driver
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return 0, ledgerError("Failed to scan drivers")
+		}
+		driver := Driver{}
+		if json.Unmarshal(valAsBytes, &driver) != nil {
+			fmt.Println("! skipping corrupt driver " + printableKey(key))
+			continue
+		}
+		drivers = append(drivers, driver)
+	}
+	keysIter.Close()
+
+	emails := []string{}
+	for _, driver := range drivers {
+		email := strings.ToLower(driver.Email)
+		emails = append(emails, email)
+		ids := byDriver[email]
+		if strings.Join(ids, keySep) == strings.Join(driver.Bookings, keySep) {
+			continue
+		}
+		driver.Bookings = ids
+		if len(ids) > 0 {
+			driver.Bookingid = ids[len(ids)-1]
+		} else {
+			driver.Bookingid = ""
+		}
+		if err := putDriver(stub, &driver); err != nil {
+			return 0, err
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(emails)
+	if err := stub.PutState(driverIndexStr, jsonAsBytes); err != nil {
+		return 0, ledgerError("Failed to write driver index")
+	}
+	return len(bookings), nil
+}
@@ -0,0 +1,294 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// approvedDriver - the steps that sign up and approve a driver with this email as a rental admin
+func approvedDriver(email string) []ledgertest.Step {
+	return []ledgertest.Step{
+		signedUp(email),
+		{Function: "review_driver", Args: []string{email}},
+		{Function: "approve_driver", Args: []string{email}},
+	}
+}
+
+// booked - the step that books a car for the driver with this email as the driver
+func booked(email string, id string) ledgertest.Step {
+	return ledgertest.Step{Function: "book_car", Args: []string{"D", email, "compact", "airport", "airport", "2016-02-01", "10:00", "2016-02-03", "10:00", id}, Setup: as(email)}
+}
+
+// bookingIs - a Step.Check that booking id of the driver with this email has status
+func bookingIs(email string, id string, status string) func(s *ledgertest.MemStub, res []byte) error {
+	booking := Bookcar{}
+	return decode(bookingKey(email, id), &booking, func() error {
+		if booking.Status != status {
+			return fmt.Errorf("booking %s is %s, want %s", id, booking.Status, status)
+		}
+		return nil
+	})
+}
+
+func TestBookingLifecycle(t *testing.T) {
+	admin := as("admin@x.com", roleRentalAdmin)
+	steps := approvedDriver("d@x.com")
+	steps = append(steps,
+		booked("d@x.com", "b1"),
+		ledgertest.Step{Function: "pickup_booking", Args: []string{"d@x.com", "b1"}, Setup: admin, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "return_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "no_show_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b1"}, Check: bookingIs("d@x.com", "b1", bookingConfirmed)},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "no_show_booking", Args: []string{"d@x.com", "b1"}, WantErr: "is not due until"},
+		ledgertest.Step{Function: "pickup_booking", Args: []string{"d@x.com", "b1"}, Check: bookingIs("d@x.com", "b1", bookingPickedUp)},
+		ledgertest.Step{Function: "cancel_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "return_booking", Args: []string{"d@x.com", "b1"}, Check: bookingIs("d@x.com", "b1", bookingReturned)},
+		ledgertest.Step{Function: "return_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "cancel_booking", Args: []string{"d@x.com", "b1"}, WantErr: wantNotAllowed},
+
+		booked("d@x.com", "b2"),
+		ledgertest.Step{Function: "cancel_booking", Args: []string{"d@x.com", "b2", "plans changed"}, Check: bookingIs("d@x.com", "b2", bookingCancelled)},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b2"}, Setup: admin, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "nope"}, WantErr: `"code":"NOT_FOUND"`},
+	)
+	_, err := ledgertest.Scenario{Name: "booking lifecycle", Setup: admin, InitArgs: []string{}, Steps: steps}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPickupNeedsAnApprovedDriver(t *testing.T) {
+	admin := as("admin@x.com", roleRentalAdmin)
+	steps := approvedDriver("d@x.com")
+	steps = append(steps,
+		booked("d@x.com", "b1"),
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b1"}, Setup: admin},
+		ledgertest.Step{Function: "suspend_driver", Args: []string{"d@x.com", "speeding"}},
+		ledgertest.Step{Function: "pickup_booking", Args: []string{"d@x.com", "b1"}, WantErr: "only approved drivers can pick up"},
+		ledgertest.Step{Function: "reinstate_driver", Args: []string{"d@x.com"}},
+		ledgertest.Step{Function: "pickup_booking", Args: []string{"d@x.com", "b1"}},
+	)
+	_, err := ledgertest.Scenario{Name: "pickup", Setup: admin, InitArgs: []string{}, Steps: steps}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteDriverCancelsOpenBookings(t *testing.T) {
+	admin := as("admin@x.com", roleRentalAdmin)
+	steps := approvedDriver("d@x.com")
+	steps = append(steps,
+		booked("d@x.com", "b1"),
+		booked("d@x.com", "b2"),
+		booked("d@x.com", "b3"),
+		booked("d@x.com", "b4"),
+		ledgertest.Step{Function: "cancel_booking", Args: []string{"d@x.com", "b3"}},
+		ledgertest.Step{Function: "delete_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b1"}, Setup: admin},
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b2"}},
+		ledgertest.Step{Function: "pickup_booking", Args: []string{"d@x.com", "b1"}},
+		ledgertest.Step{Function: "delete_driver", Args: []string{"d@x.com"}, WantErr: "must be returned first"},
+		ledgertest.Step{Function: "return_booking", Args: []string{"d@x.com", "b1"}},
+		ledgertest.Step{Function: "delete_driver", Args: []string{"D@x.com"}, Want: `{"email":"d@x.com","cancelled":["b2","b4"]}`},
+		ledgertest.Step{Function: "get_booking", Args: []string{"d@x.com", "b1"}, Query: true, Check: bookingIs("d@x.com", "b1", bookingReturned)},
+		ledgertest.Step{Function: "get_booking", Args: []string{"d@x.com", "b2"}, Query: true, Check: bookingIs("d@x.com", "b2", bookingCancelled)},
+		ledgertest.Step{Function: "get_booking", Args: []string{"d@x.com", "b4"}, Query: true, Check: bookingIs("d@x.com", "b4", bookingCancelled)},
+		ledgertest.Step{Function: "delete_driver", Args: []string{"d@x.com"}, WantErr: `"code":"NOT_FOUND"`},
+	)
+	_, err := ledgertest.Scenario{
+		Name:     "delete driver",
+		Setup:    admin,
+		InitArgs: []string{},
+		Steps:    steps,
+		State: map[string]string{
+			driverKey("d@x.com"): "",
+			driverIndexStr:       `[]`,
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBookingDates(t *testing.T) {
+	clock := ledgertest.NewFakeClock(time.Date(2016, time.January, 2, 10, 0, 0, 0, time.UTC))
+	booking := func(pickupdate, pickuptime, dropoffdate, dropofftime string) []string {
+		return []string{"D", "d@x.com", "compact", "airport", "airport", pickupdate, pickuptime, dropoffdate, dropofftime, "b1"}
+	}
+	_, err := ledgertest.Scenario{
+		Name:     "booking dates",
+		Setup:    as("d@x.com"),
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			signedUp("d@x.com"),
+			{Function: "book_car", Args: booking("2015-12-31", "10:00", "2016-01-03", "10:00"), WantErr: "is in the past"},
+			{Function: "book_car", Args: booking("2016-01-02", "09:59", "2016-01-03", "10:00"), WantErr: "is in the past"},
+			{Function: "book_car", Args: booking("2016-01-03", "10:00", "2016-01-02", "10:00"), WantErr: "dropoff must be after the pickup"},
+			{Function: "book_car", Args: booking("2016-01-03", "10:00", "2016-01-03", "10:00"), WantErr: "dropoff must be after the pickup"},
+			{Function: "book_car", Args: booking("01/02/2016", "10:00", "2016-02-03", "10:00"), WantErr: `"name":"pickupdate"`},
+			{Function: "book_car", Args: booking("2016-01-02", "10:00", "02/01/2016", "10:00"), WantErr: `"name":"dropoffdate"`},
+			{Function: "book_car", Args: booking("2016-01-02", "10:00", "2016-01-02", "10:30"), Check: bookingIs("d@x.com", "b1", bookingRequested)}, //now is not in the past
+			{Function: "list_bookings_by_date", Args: []string{"2016-01-03", "2016-01-01"}, Query: true, WantErr: "must not be before"},
+			{Function: "list_bookings_by_date", Args: []string{"01/01/2016", "2016-01-31"}, Query: true, WantErr: `"name":"from_date"`},
+			{Function: "list_bookings_by_date", Args: []string{"2016-01-02", "2016-01-02"}, Query: true, Check: func(s *ledgertest.MemStub, res []byte) error {
+				var page BookingPage
+				if json.Unmarshal(res, &page) != nil || len(page.Bookings) != 1 || page.Bookings[0].Bookingid != "b1" {
+					return fmt.Errorf("bookings on 2016-01-02: %s", res)
+				}
+				return nil
+			}},
+			{Function: "book_car", Args: booking("2016-01-03", "09:00", "2016-01-04", "10:00"), WantErr: "is in the past", Setup: func(s *ledgertest.MemStub) {
+				clock.Advance(24 * time.Hour)
+			}},
+		},
+	}.Run(&SimpleChaincode{clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoShowOnceThePickupIsDue(t *testing.T) {
+	clock := ledgertest.NewFakeClock(ledgertest.Epoch)
+	admin := as("admin@x.com", roleRentalAdmin)
+	at := func(t time.Time) func(s *ledgertest.MemStub) {
+		return func(s *ledgertest.MemStub) {
+			admin(s)
+			clock.Set(t)
+		}
+	}
+	pickup := time.Date(2016, time.February, 1, 10, 0, 0, 0, time.UTC)
+	booking := Bookcar{}
+	steps := append(approvedDriver("d@x.com"),
+		booked("d@x.com", "b1"),
+		ledgertest.Step{Function: "confirm_booking", Args: []string{"d@x.com", "b1"}, Setup: admin},
+		ledgertest.Step{Function: "no_show_booking", Args: []string{"d@x.com", "b1"}, WantErr: "is not due until 2016-02-01T10:00:00Z"},
+		ledgertest.Step{Function: "no_show_booking", Args: []string{"d@x.com", "b1"}, WantErr: "is not due until", Setup: at(pickup.Add(-time.Second))},
+		ledgertest.Step{Function: "no_show_booking", Args: []string{"d@x.com", "b1"}, Setup: at(pickup), Check: decode(bookingKey("d@x.com", "b1"), &booking, func() error {
+			last := booking.Statushistory[len(booking.Statushistory)-1]
+			if booking.Status != bookingNoShow || last.Timestamp != toMillis(pickup) {
+				return fmt.Errorf("booking is %s since %d, want %s since %d", booking.Status, last.Timestamp, bookingNoShow, toMillis(pickup))
+			}
+			return nil
+		})},
+	)
+	_, err := ledgertest.Scenario{Name: "no show", Setup: admin, InitArgs: []string{}, Steps: steps}.Run(&SimpleChaincode{clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bookingLedger - a ledger with these bookings, listed as driver, id, pickup location and pickup date
+//
+//	a@x.com a1 airport 2016-03-05, a@x.com a2 station 2016-02-10, a@x.com a3 Airport 2016-02-20,
+//	b@x.com b1 airport 2016-02-20, b@x.com b2 station 2016-03-01
+func bookingLedger(t *testing.T) *ledgertest.MemStub {
+	signup := func(email string) ledgertest.Step {
+		step := signedUp(email)
+		step.Setup = as(email)
+		return step
+	}
+	bookedAt := func(email, id, location, pickupdate string) ledgertest.Step {
+		return ledgertest.Step{Function: "book_car", Args: []string{"D", email, "compact", location, location, pickupdate, "10:00", "2016-04-01", "10:00", id}, Setup: as(email)}
+	}
+	s, err := ledgertest.Scenario{
+		Name:     "bookings",
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			signup("a@x.com"),
+			signup("b@x.com"),
+			bookedAt("a@x.com", "a1", "airport", "2016-03-05"),
+			bookedAt("b@x.com", "b2", "station", "2016-03-01"),
+			bookedAt("a@x.com", "a3", "Airport", "2016-02-20"),
+			bookedAt("b@x.com", "b1", "airport", "2016-02-20"),
+			bookedAt("a@x.com", "a2", "station", "2016-02-10"),
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// bookingPages - the booking ids on every page of a booking query, following the bookmarks. args go before the page size.
+func bookingPages(s *ledgertest.MemStub, function string, args []string, size string) (string, error) {
+	var pages []string
+	bookmark := ""
+	for len(pages) < 10 {
+		res, err := s.Query(new(SimpleChaincode), function, append(append([]string{}, args...), size, bookmark))
+		if err != nil {
+			return "", err
+		}
+		page := BookingPage{}
+		if err := json.Unmarshal(res, &page); err != nil {
+			return "", err
+		}
+		ids := ""
+		for _, booking := range page.Bookings {
+			ids += booking.Bookingid
+		}
+		pages = append(pages, ids)
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	return fmt.Sprint(pages), nil
+}
+
+func TestListBookings(t *testing.T) {
+	s := bookingLedger(t)
+	for _, tc := range []struct {
+		function string
+		args     []string
+		size     string
+		want     string
+	}{
+		//a driver's bookings come in booking id order
+		{"list_bookings_by_driver", []string{"a@x.com"}, "10", "[a1a2a3]"},
+		{"list_bookings_by_driver", []string{"A@X.com"}, "2", "[a1a2 a3]"},
+		{"list_bookings_by_driver", []string{"b@x.com"}, "2", "[b1b2]"},
+		{"list_bookings_by_driver", []string{"c@x.com"}, "2", "[]"},
+
+		//a location's bookings come earliest pickup first, then by driver
+		{"list_bookings_by_location", []string{"airport"}, "10", "[a3b1a1]"},
+		{"list_bookings_by_location", []string{" AIRPORT"}, "2", "[a3b1 a1]"},
+		{"list_bookings_by_location", []string{"airport"}, "1", "[a3 b1 a1]"},
+		{"list_bookings_by_location", []string{"station"}, "1", "[a2 b2]"},
+		{"list_bookings_by_location", []string{"harbour"}, "1", "[]"},
+
+		//bookings picked up between two dates, both inclusive
+		{"list_bookings_by_date", []string{"2016-01-01", "2016-12-31"}, "10", "[a2a3b1b2a1]"},
+		{"list_bookings_by_date", []string{"2016-02-15", "2016-03-01"}, "2", "[a3b1 b2]"},
+		{"list_bookings_by_date", []string{"2016-02-20", "2016-02-20"}, "1", "[a3 b1]"},
+		{"list_bookings_by_date", []string{"2016-03-01", "2016-03-01"}, "10", "[b2]"},
+		{"list_bookings_by_date", []string{"2016-02-21", "2016-02-29"}, "10", "[]"},
+		{"list_bookings_by_date", []string{"2016-01-01", "2016-02-09"}, "10", "[]"},
+	} {
+		got, err := bookingPages(s, tc.function, tc.args, tc.size)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %q size %s: got %s %v, want %s", tc.function, tc.args, tc.size, got, err, tc.want)
+		}
+	}
+}
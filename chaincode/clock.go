@@ -0,0 +1,66 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Clock is the only source of time for the chaincode. Anything written to the
+// ledger must get its time from here and never from time.Now(), otherwise
+// endorsing peers would compute different results for the same transaction.
+type Clock interface {
+	Now(stub shim.ChaincodeStubInterface) (time.Time, error)
+}
+
+// txClock reads the time from the timestamp of the transaction being run.
+type txClock struct{}
+
+func (txClock) Now(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, ledgerError("Failed to get transaction timestamp")
+	}
+	if ts == nil {
+		return time.Time{}, ledgerError("Transaction has no timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// ============================================================================================================================
+// now - current time of the transaction, from the chaincode's clock
+// ============================================================================================================================
+func (t *SimpleChaincode) now(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	if t.clock == nil {
+		return txClock{}.Now(stub)
+	}
+	return t.clock.Now(stub)
+}
+
+// ============================================================================================================================
+// Make Timestamp - create a timestamp in ms from the transaction time
+// ============================================================================================================================
+func (t *SimpleChaincode) makeTimestamp(stub shim.ChaincodeStubInterface) (int64, error) {
+	now, err := t.now(stub)
+	if err != nil {
+		return 0, err
+	}
+	return now.UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond)), nil
+}
@@ -0,0 +1,205 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Passwords are never stored. The ledger keeps a salted PBKDF2-SHA256 hash in
+// the form "pbkdf2-sha256$<iterations>$<salt>$<hash>". The salt is derived
+// from the transaction id and the record key rather than a random source, so
+// every endorsing peer computes the same hash.
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 10000
+)
+
+// ============================================================================================================================
+// hashPassword - salted hash of a password for the record stored under key
+// ============================================================================================================================
+func hashPassword(stub shim.ChaincodeStubInterface, key string, password string) string {
+	seed := sha256.Sum256([]byte(stub.GetTxID() + "|" + key))
+	salt := seed[:16]
+	hash := pbkdf2(password, salt, hashIterations)
+	return hashScheme + "$" + strconv.Itoa(hashIterations) + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(hash)
+}
+
+// ============================================================================================================================
+// checkPassword - true if password matches the stored hash
+// ============================================================================================================================
+func checkPassword(stored string, password string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(pbkdf2(password, salt, iterations), want)
+}
+
+// pbkdf2 - PBKDF2 with HMAC-SHA256 (RFC 2898), one block of output is all we need
+func pbkdf2(password string, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, []byte(password))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for x := range out {
+			out[x] ^= u[x]
+		}
+	}
+	return out
+}
+
+// ============================================================================================================================
+// Verify Driver Credentials - query, does the password match the driver's, returns {"match": true|false} only
+// ============================================================================================================================
+func (t *SimpleChaincode) verify_driver_credentials(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0          1
+	// "email", "password"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	driver := Driver{}
+	found, err := getRecord(stub, driverKey(strings.ToLower(args[0])), docDriver, &driver)
+	if err != nil {
+		return nil, err
+	}
+	return credentialResult(found && driver.matches(args[1]))
+}
+
+// ============================================================================================================================
+// Verify Sysadmin - query, does the password match the system admin's, returns {"match": true|false} only
+// ============================================================================================================================
+func (t *SimpleChaincode) verify_sysadmin(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0          1
+	// "userid", "password"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	admin := Adminlogin{}
+	found, err := getRecord(stub, adminKey(args[0]), docAdmin, &admin)
+	if err != nil {
+		return nil, err
+	}
+	return credentialResult(found && admin.Userid == args[0] && admin.matches(args[1]))
+}
+
+// CredentialResult is the only thing a credential check reveals
+type CredentialResult struct {
+	Match bool `json:"match"`
+}
+
+func credentialResult(match bool) ([]byte, error) {
+	return json.Marshal(CredentialResult{Match: match})
+}
+
+// matches - check a password against the driver's hash, or the plaintext of a record not migrated yet
+func (d *Driver) matches(password string) bool {
+	if d.Passwordhash != "" {
+		return checkPassword(d.Passwordhash, password)
+	}
+	return d.Password != "" && hmac.Equal([]byte(d.Password), []byte(password))
+}
+
+// matches - check a password against the admin's hash, or the plaintext of a record not migrated yet
+func (a *Adminlogin) matches(password string) bool {
+	if a.Passwordhash != "" {
+		return checkPassword(a.Passwordhash, password)
+	}
+	return a.Password != "" && hmac.Equal([]byte(a.Password), []byte(password))
+}
+
+// ============================================================================================================================
+// Migrate Passwords - hash the plaintext password of every driver and admin record in place
+// ============================================================================================================================
+func (t *SimpleChaincode) migrate_passwords(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start migrate passwords")
+	var migrated = []string{}
+
+	keysIter, err := stub.RangeQueryState("", lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan keys")
+	}
+	defer keysIter.Close()
+
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan keys")
+		}
+		if isSystemKey(key) {
+			continue
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal(valAsBytes, &fields) != nil {
+			continue //corrupt, repair_records first
+		}
+		if password, _ := fields["password"].(string); password == "" {
+			continue //nothing in clear text
+		}
+
+		switch rec, docType := classifyRecord(fields); docType {
+		case docDriver:
+			driver := rec.(*Driver)
+			json.Unmarshal(valAsBytes, driver)
+			driver.Passwordhash = hashPassword(stub, key, driver.Password)
+			driver.Password = ""
+			err = putRecord(stub, key, docDriver, driver)
+		case docAdmin:
+			admin := rec.(*Adminlogin)
+			json.Unmarshal(valAsBytes, admin)
+			admin.Passwordhash = hashPassword(stub, key, admin.Password)
+			admin.Password = ""
+			err = putRecord(stub, key, docAdmin, admin)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, printableKey(key))
+	}
+
+	fmt.Println("- end migrate passwords, migrated " + strconv.Itoa(len(migrated)))
+	return json.Marshal(map[string][]string{"migrated": migrated})
+}
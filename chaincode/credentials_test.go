@@ -0,0 +1,96 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+const (
+	match   = `{"match":true}`
+	noMatch = `{"match":false}`
+)
+
+// credentialChecks - the steps that check each password against the driver d@x.com and the admin root, whose record
+// read_sysadmin returns as admin
+func credentialChecks(driverPassword string, adminPassword string, admin string) []ledgertest.Step {
+	return []ledgertest.Step{
+		{Function: "verify_driver_credentials", Args: []string{"d@x.com", driverPassword}, Query: true, Want: match},
+		{Function: "verify_driver_credentials", Args: []string{"D@X.com", driverPassword}, Query: true, Want: match},
+		{Function: "verify_driver_credentials", Args: []string{"d@x.com", driverPassword + "x"}, Query: true, Want: noMatch},
+		{Function: "verify_driver_credentials", Args: []string{"nobody@x.com", driverPassword}, Query: true, Want: noMatch},
+		{Function: "verify_sysadmin", Args: []string{"root", adminPassword}, Query: true, Want: match},
+		{Function: "verify_sysadmin", Args: []string{"root", adminPassword + "x"}, Query: true, Want: noMatch},
+		{Function: "verify_sysadmin", Args: []string{"ROOT", adminPassword}, Query: true, Want: noMatch},
+		{Function: "verify_sysadmin", Args: []string{"nobody", adminPassword}, Query: true, Want: noMatch},
+		{Function: "read_sysadmin", Args: []string{"root"}, Query: true, Want: admin},
+		{Function: "read_sysadmin", Args: []string{"nobody"}, Query: true, WantErr: `"code":"NOT_FOUND"`},
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	driver := Driver{}
+	_, err := ledgertest.Scenario{
+		Name:     "credentials",
+		Setup:    as("d@x.com"),
+		InitArgs: []string{"root", "secret"},
+		Steps: append([]ledgertest.Step{
+			{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", "d@x.com", "1", "pw", "addr", "", "", "", "", ""}, Check: decode(driverKey("d@x.com"), &driver, func() error {
+				if driver.Password != "" || driver.Passwordhash == "" {
+					return fmt.Errorf("password stored as %+v", driver)
+				}
+				return nil
+			})},
+		}, credentialChecks("pw", "secret", `{"docType":"admin","version":1,"userid":"root"}`)...),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyPasswords(t *testing.T) {
+	driver := Driver{}
+	admin := Adminlogin{}
+	steps := credentialChecks("old", "toor", `{"userid":"root"}`) //not migrated, so no envelope either
+	migrated := func(s *ledgertest.MemStub, res []byte) error {
+		if err := json.Unmarshal(s.Get(driverKey("d@x.com")), &driver); err != nil || driver.Password != "" || driver.Passwordhash == "" {
+			return fmt.Errorf("driver not migrated %+v", driver)
+		}
+		if err := json.Unmarshal(s.Get(adminKey("root")), &admin); err != nil || admin.Password != "" || admin.Passwordhash == "" {
+			return fmt.Errorf("admin not migrated %+v", admin)
+		}
+		return nil
+	}
+	steps = append(steps, ledgertest.Step{Function: "migrate_passwords", Setup: asOperator, Check: migrated})
+	steps = append(steps, credentialChecks("old", "toor", `{"docType":"admin","version":1,"userid":"root"}`)...)
+	_, err := ledgertest.Scenario{
+		Name: "legacy passwords",
+		Setup: func(s *ledgertest.MemStub) {
+			s.State[driverKey("d@x.com")] = []byte(`{"name":"D","dl":"dl","dob":"1990-01-01","email":"d@x.com","mobile":"1","password":"old","address":"addr"}`)
+			s.State[adminKey("root")] = []byte(legacyAdmin("root", "toor"))
+		},
+		Steps: steps,
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Each kind of record has its own delete, which builds the key from its own
+// namespace, so no argument can reach a system key such as _opentrades, and
+// which cleans up everything that points at the record: delete_marble, the
+// old delete, retires the marble, see retire.go, and delete_driver removes a
+// driver after cancelling their open bookings. Bookings are never removed,
+// cancelling one keeps it, see bookings.go.
+
+// DriverDeletion is the result of delete_driver
+type DriverDeletion struct {
+	Email     string   `json:"email"`
+	Cancelled []string `json:"cancelled"` //ids of the driver's requested and confirmed bookings, now cancelled
+}
+
+// ============================================================================================================================
+// Delete Driver - remove a driver and their entry in the driver index, cancelling their open bookings. A driver with a
+// car picked up can not be deleted until it is returned.
+// ============================================================================================================================
+func (t *SimpleChaincode) delete_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//          0
+	// "bob@example.com"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	fmt.Println("- start delete driver")
+	email := strings.ToLower(args[0])
+	driver := Driver{}
+	found, err := getRecord(stub, driverKey(email), docDriver, &driver)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "Driver " + email + " does not exist", Arg: 0}
+	}
+
+	bookings, err := driverBookings(stub, email)
+	if err != nil {
+		return nil, err
+	}
+	for _, booking := range bookings {
+		if bookingStatus(booking) == bookingPickedUp {
+			return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Driver " + email + " has picked up the car of booking " + booking.Bookingid + ", it must be returned first", Arg: 0}
+		}
+	}
+	result := DriverDeletion{Email: email, Cancelled: []string{}}
+	stale := []string{email}
+	for _, booking := range bookings {
+		stale = append(stale, booking.Bookingid) //book_car used to list booking ids in _driverindex
+		if !canMove(bookingTransitions, bookingStatus(booking), bookingCancelled) {
+			continue
+		}
+		_, err = t.moveBooking(stub, []string{email, booking.Bookingid}, bookingCancelled, "driver deleted")
+		if err != nil {
+			return nil, err
+		}
+		result.Cancelled = append(result.Cancelled, booking.Bookingid)
+	}
+	err = stub.DelState(driverKey(email))
+	if err != nil {
+		return nil, ledgerError("Failed to delete driver " + email)
+	}
+	err = removeFromDriverIndex(stub, stale)
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, eventDriverDeleted, driverEvent(driver))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end delete driver")
+	return json.Marshal(result)
+}
+
+// driverBookings - every booking stored for the driver with this (lower case) email
+func driverBookings(stub shim.ChaincodeStubInterface, email string) ([]Bookcar, error) {
+	start := makeKey(nsBooking, email)
+	keysIter, err := stub.RangeQueryState(start, start+lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan bookings of " + email)
+	}
+	defer keysIter.Close()
+
+	var bookings []Bookcar
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan bookings of " + email)
+		}
+		booking := Bookcar{}
+		if json.Unmarshal(valAsBytes, &booking) != nil {
+			return nil, newError(CodeCorruptRecord, "Stored "+printableKey(key)+" is corrupt")
+		}
+		bookings = append(bookings, booking)
+	}
+	return bookings, nil
+}
+
+// removeFromDriverIndex - drop every occurrence of these emails and booking ids from _driverindex
+func removeFromDriverIndex(stub shim.ChaincodeStubInterface, values []string) error {
+	indexAsBytes, err := stub.GetState(driverIndexStr)
+	if err != nil {
+		return ledgerError("Failed to get driver index")
+	}
+	var driverIndex []string
+	json.Unmarshal(indexAsBytes, &driverIndex)
+
+	drop := map[string]bool{}
+	for _, value := range values {
+		drop[value] = true
+	}
+	kept := []string{}
+	for _, entry := range driverIndex {
+		if !drop[entry] {
+			kept = append(kept, entry)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(kept)
+	err = stub.PutState(driverIndexStr, jsonAsBytes)
+	if err != nil {
+		return ledgerError("Failed to write driver index")
+	}
+	return nil
+}
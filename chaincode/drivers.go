@@ -0,0 +1,358 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// A driver signs up as pending and only a rental admin moves them along:
+//
+//	pending -> under_review -> approved -> suspended <-> reinstated
+//	                       \-> rejected
+//
+// Each move has its own Invoke function, which refuses any move not in
+// driverTransitions and records the acting admin, the time and the reason.
+// The status fields of a driver are never written any other way.
+const (
+	statusPending     = "pending"
+	statusUnderReview = "under_review"
+	statusApproved    = "approved"
+	statusRejected    = "rejected"
+	statusSuspended   = "suspended"
+	statusReinstated  = "reinstated"
+)
+
+// driverTransitions are the statuses each status can move to
+var driverTransitions = map[string][]string{
+	statusPending:     {statusUnderReview},
+	statusUnderReview: {statusApproved, statusRejected},
+	statusApproved:    {statusSuspended},
+	statusReinstated:  {statusSuspended},
+	statusSuspended:   {statusReinstated},
+	statusRejected:    {},
+}
+
+// StatusChange is one move of a driver or a booking through its lifecycle
+type StatusChange struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	By        string `json:"by"`        //username attribute of the caller who made the move
+	Timestamp int64  `json:"timestamp"` //utc timestamp of the transaction, in milliseconds
+	Reason    string `json:"reason,omitempty"`
+}
+
+// driverStatus - the lifecycle status of a stored driver, drivers saved before the lifecycle existed count as pending
+func driverStatus(driver Driver) string {
+	status := strings.ToLower(strings.TrimSpace(driver.Status))
+	if _, known := driverTransitions[status]; !known {
+		return statusPending
+	}
+	return status
+}
+
+// canMove - true if transitions let a record go from status from to status to
+func canMove(transitions map[string][]string, from string, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// moveDriver - move the driver with email args[0] to status to, the transition Invoke functions all end up here
+// ============================================================================================================================
+func (t *SimpleChaincode) moveDriver(stub shim.ChaincodeStubInterface, args []string, to string, reason string) ([]byte, error) {
+	driver, err := getDriver(stub, args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	err = t.transition(stub, &driver, to, reason)
+	if err != nil {
+		return nil, err
+	}
+	err = putDriver(stub, &driver)
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventDriverStatusChanged, driverEvent(driver))
+}
+
+// transition - move driver to status to in place, recording the acting admin, the time and the reason
+func (t *SimpleChaincode) transition(stub shim.ChaincodeStubInterface, driver *Driver, to string, reason string) error {
+	admin := getCaller(stub)
+	if admin.IsUser(driver.Email) {
+		return notAllowed(admin, "can not change their own status", 0)
+	}
+	from := driverStatus(*driver)
+	if !canMove(driverTransitions, from, to) {
+		return &ChaincodeError{Code: CodeNotAllowed, Message: "Driver " + driver.Email + " is " + from + " and can not become " + to, Arg: 0}
+	}
+
+	timestamp, err := t.makeTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	driver.Status = to
+	driver.Modifyby = admin.Id
+	if emailPattern.MatchString(admin.Id) {
+		driver.Adminemail = admin.Id
+	}
+	if to == statusRejected {
+		driver.Rejectreason = reason
+	} else if to == statusApproved || to == statusReinstated {
+		driver.Rejectreason = ""
+	}
+	driver.Statushistory = append(driver.Statushistory, StatusChange{From: from, To: to, By: admin.Id, Timestamp: timestamp, Reason: reason})
+	fmt.Println("- driver " + driver.Email + " moved from " + from + " to " + to)
+	return nil
+}
+
+// keepStatusFields - error unless args[from:from+4], the status, modifyby, adminemail and rejectreason of a driver in
+// signup_driver and set_status, are what is stored. An empty status leaves the status as it is.
+func keepStatusFields(stored Driver, args []string, from int) error {
+	if status := strings.ToLower(strings.TrimSpace(args[from])); status != "" && status != driverStatus(stored) {
+		return &ChaincodeError{Code: CodeNotAllowed, Message: "Driver status can only change through review_driver, approve_driver, reject_driver, suspend_driver or reinstate_driver", Arg: from}
+	}
+	for x, value := range []string{stored.Modifyby, stored.Adminemail, stored.Rejectreason} {
+		if args[from+1+x] != value {
+			return &ChaincodeError{Code: CodeNotAllowed, Message: "modifyby, adminemail and rejectreason are set by the driver transitions", Arg: from + 1 + x}
+		}
+	}
+	return nil
+}
+
+// profileArgs are the arguments of set_status that hold driver fields, each checked against driverFields
+var profileArgs = []struct {
+	name string
+	i    int
+}{{"name", 1}, {"dl", 2}, {"dob", 3}, {"mobile", 4}, {"password", 5}, {"address", 6}, {"anycomment", 11}}
+
+// setProfile - set the driver fields of set_status on driver, the same fields update_driver lets the caller change.
+// Fields given as stored are left alone, so a driver can send back their whole record with only their own fields changed.
+func setProfile(stub shim.ChaincodeStubInterface, driver *Driver, args []string) error {
+	caller := getCaller(stub)
+	stored := map[string]string{"name": driver.Name, "dl": driver.DL, "dob": driver.DOB, "mobile": driver.Mobile,
+		"address": driver.Address, "anycomment": driver.Anycomment}
+	for _, arg := range profileArgs {
+		value := args[arg.i]
+		if arg.name == "password" && driver.matches(value) || arg.name != "password" && stored[arg.name] == value {
+			continue
+		}
+		field := driverFields[arg.name]
+		if !field.self && !caller.Is(roleRentalAdmin) {
+			return notAllowed(caller, "is not a rental admin and can not update "+arg.name, arg.i)
+		}
+		field.set(stub, driver, value)
+	}
+	return nil
+}
+
+// getDriver - the driver with this email, args[i], which must exist
+func getDriver(stub shim.ChaincodeStubInterface, email string, i int) (Driver, error) {
+	email = strings.ToLower(email)
+	driver := Driver{}
+	found, err := getRecord(stub, driverKey(email), docDriver, &driver)
+	if err != nil {
+		return driver, err
+	}
+	if !found {
+		return driver, &ChaincodeError{Code: CodeNotFound, Message: "Driver " + email + " does not exist", Arg: i}
+	}
+	return driver, nil
+}
+
+// putDriver - store a driver under its email as the next revision, see update_driver
+func putDriver(stub shim.ChaincodeStubInterface, driver *Driver) error {
+	driver.Revision++
+	return putRecord(stub, driverKey(strings.ToLower(driver.Email)), docDriver, driver)
+}
+
+// ============================================================================================================================
+// Review Driver - start reviewing a pending driver
+// ============================================================================================================================
+func (t *SimpleChaincode) review_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0
+	// "bob@example.com"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	return t.moveDriver(stub, args, statusUnderReview, "")
+}
+
+// ============================================================================================================================
+// Approve Driver - let a driver under review book cars
+// ============================================================================================================================
+func (t *SimpleChaincode) approve_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0
+	// "bob@example.com"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	return t.moveDriver(stub, args, statusApproved, "")
+}
+
+// ============================================================================================================================
+// Reject Driver - turn down a driver under review, the reason is stored in Rejectreason
+// ============================================================================================================================
+func (t *SimpleChaincode) reject_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "reason"
+	if len(args) != 2 {
+		return nil, argCountError("2")
+	}
+	return t.moveDriver(stub, args, statusRejected, args[1])
+}
+
+// ============================================================================================================================
+// Suspend Driver - stop an approved or reinstated driver
+// ============================================================================================================================
+func (t *SimpleChaincode) suspend_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                1
+	// "bob@example.com", "reason"     reason is optional
+	if len(args) < 1 || len(args) > 2 {
+		return nil, argCountError("1 or 2")
+	}
+	var reason string
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	return t.moveDriver(stub, args, statusSuspended, reason)
+}
+
+// ============================================================================================================================
+// Reinstate Driver - lift the suspension of a driver
+// ============================================================================================================================
+func (t *SimpleChaincode) reinstate_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0
+	// "bob@example.com"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	return t.moveDriver(stub, args, statusReinstated, "")
+}
+
+// driverField is a field of a driver that update_driver can change
+type driverField struct {
+	spec argSpec //checked with checkArg, the same as an argument
+	self bool    //the driver may change it themselves, rental admins may change every field
+	set  func(stub shim.ChaincodeStubInterface, driver *Driver, value string)
+}
+
+// driverFields are the fields update_driver accepts in its patch. The status goes through transition, the email is
+// the key and the admin fields are set by the transitions, so none of those can be patched directly.
+var driverFields = map[string]driverField{
+	"name":    {argSpec{Name: "name", Required: true}, false, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.Name = v }},
+	"dl":      {argSpec{Name: "dl", Required: true}, false, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.DL = v }},
+	"dob":     {argSpec{Name: "dob", Required: true, Format: formatDate}, false, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.DOB = v }},
+	"mobile":  {argSpec{Name: "mobile", Required: true}, true, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.Mobile = v }},
+	"address": {argSpec{Name: "address", Required: true}, true, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.Address = v }},
+	"password": {argSpec{Name: "password", Required: true}, true, func(stub shim.ChaincodeStubInterface, d *Driver, v string) {
+		d.Password = ""
+		d.Passwordhash = hashPassword(stub, strings.ToLower(d.Email), v)
+	}},
+	"anycomment":   {argSpec{Name: "anycomment"}, true, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.Anycomment = v }},
+	"rejectreason": {argSpec{Name: "rejectreason"}, false, func(stub shim.ChaincodeStubInterface, d *Driver, v string) { d.Rejectreason = v }},
+	"status":       {argSpec{Name: "status", Required: true}, false, nil},
+}
+
+// DriverRevision is the result of update_driver, the revision to send with the next update
+type DriverRevision struct {
+	Email    string `json:"email"`
+	Revision int    `json:"revision"`
+}
+
+// ============================================================================================================================
+// Update Driver - change only the fields in a JSON patch, if the driver is still at the revision the caller read
+// ============================================================================================================================
+func (t *SimpleChaincode) update_driver(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//         0                          1                        2
+	// "bob@example.com", "{\"mobile\":\"555-0100\"}", "revision"
+	if len(args) != 3 {
+		return nil, argCountError("3")
+	}
+	fmt.Println("- start update driver")
+	driver, err := getDriver(stub, args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	revision, _ := strconv.Atoi(args[2]) //checked by the schema
+	if revision != driver.Revision {
+		return nil, &ChaincodeError{Code: CodeConflict, Message: "Driver " + driver.Email + " is at revision " + strconv.Itoa(driver.Revision) + ", read it again", Arg: 2}
+	}
+
+	var patch map[string]string
+	if json.Unmarshal([]byte(args[1]), &patch) != nil || len(patch) == 0 {
+		return nil, argError(1, "Expecting a JSON object of the fields to change, each value a string")
+	}
+	caller := getCaller(stub)
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names) //report problems in a stable order
+	for _, name := range names {
+		field, ok := driverFields[name]
+		if !ok {
+			return nil, argError(1, "Field "+name+" can not be updated")
+		}
+		if !field.self && !caller.Is(roleRentalAdmin) {
+			return nil, notAllowed(caller, "is not a rental admin and can not update "+name, 1)
+		}
+		if msg := checkArg(field.spec, patch[name]); msg != "" {
+			return nil, argError(1, "Field "+name+" "+msg)
+		}
+	}
+
+	for _, name := range names {
+		if set := driverFields[name].set; set != nil {
+			set(stub, &driver, patch[name])
+		}
+	}
+	event := eventDriverUpdated
+	if to, ok := patch["status"]; ok {
+		to = strings.ToLower(strings.TrimSpace(to))
+		if to == statusRejected && patch["rejectreason"] == "" {
+			return nil, argError(1, "A rejectreason is required to reject a driver")
+		}
+		err = t.transition(stub, &driver, to, patch["rejectreason"])
+		if err != nil {
+			return nil, err
+		}
+		event = eventDriverStatusChanged
+	}
+
+	err = putDriver(stub, &driver)
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, event, driverEvent(driver))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end update driver")
+	return json.Marshal(DriverRevision{Email: driver.Email, Revision: driver.Revision})
+}
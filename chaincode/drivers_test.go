@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+const wantNotAllowed = `"code":"NOT_ALLOWED"`
+
+// signedUp - the step that signs up a driver with this email
+func signedUp(email string) ledgertest.Step {
+	return ledgertest.Step{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", email, "1", "pw", "addr", "", "", "", "", ""}}
+}
+
+// driverIs - a Step.Check that the driver with this email has status
+func driverIs(email string, status string) func(s *ledgertest.MemStub, res []byte) error {
+	driver := Driver{}
+	return decode(driverKey(email), &driver, func() error {
+		if driver.Status != status {
+			return fmt.Errorf("driver %s is %s, want %s", email, driver.Status, status)
+		}
+		return nil
+	})
+}
+
+func TestDriverLifecycle(t *testing.T) {
+	admin := as("admin@x.com", roleRentalAdmin)
+	_, err := ledgertest.Scenario{
+		Name:     "driver lifecycle",
+		Setup:    admin,
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			signedUp("d@x.com"),
+			{Function: "approve_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "suspend_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "review_driver", Args: []string{"d@x.com"}, Check: driverIs("d@x.com", statusUnderReview)},
+			{Function: "review_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "reject_driver", Args: []string{"d@x.com", ""}, WantErr: `"name":"reason"`},
+			{Function: "reinstate_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "approve_driver", Args: []string{"d@x.com"}, Check: driverIs("d@x.com", statusApproved)},
+			{Function: "reject_driver", Args: []string{"d@x.com", "too late"}, WantErr: wantNotAllowed},
+			{Function: "reinstate_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "suspend_driver", Args: []string{"d@x.com", "speeding"}, Check: driverIs("d@x.com", statusSuspended)},
+			{Function: "suspend_driver", Args: []string{"d@x.com"}, WantErr: wantNotAllowed},
+			{Function: "reinstate_driver", Args: []string{"d@x.com"}, Check: driverIs("d@x.com", statusReinstated)},
+
+			signedUp("e@x.com"),
+			{Function: "review_driver", Args: []string{"e@x.com"}},
+			{Function: "reject_driver", Args: []string{"e@x.com", "no licence"}, Check: driverIs("e@x.com", statusRejected)},
+			{Function: "approve_driver", Args: []string{"e@x.com"}, WantErr: wantNotAllowed},
+			{Function: "review_driver", Args: []string{"e@x.com"}, WantErr: wantNotAllowed},
+
+			{Function: "review_driver", Args: []string{"nobody@x.com"}, WantErr: `"code":"NOT_FOUND"`},
+			signedUp("admin@x.com"),
+			{Function: "review_driver", Args: []string{"admin@x.com"}, WantErr: "can not change their own status"},
+			{Function: "approve_driver", Args: []string{"e@x.com"}, Setup: as("e@x.com"), WantErr: wantNotAllowed},
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusFieldsOnlyChangeThroughTransitions(t *testing.T) {
+	profile := func(status, modifyby, adminemail, rejectreason string) []string {
+		return []string{"d@x.com", "D", "dl", "1990-01-01", "2", "pw", "addr", status, modifyby, adminemail, rejectreason, ""}
+	}
+	_, err := ledgertest.Scenario{
+		Name:     "status fields",
+		Setup:    as("admin@x.com", roleRentalAdmin),
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", "d@x.com", "1", "pw", "addr", statusApproved, "", "", "", ""}, WantErr: wantNotAllowed},
+			{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", "d@x.com", "1", "pw", "addr", "", "", "", "fine", ""}, WantErr: wantNotAllowed},
+			{Function: "signup_driver", Args: []string{"D", "dl", "1990-01-01", "d@x.com", "1", "pw", "addr", statusPending, "", "", "", ""}, Setup: as("d@x.com")},
+			{Function: "set_status", Args: profile(statusApproved, "", "", ""), WantErr: wantNotAllowed},
+			{Function: "set_status", Args: profile(statusApproved, "", "", ""), WantErr: wantNotAllowed, Setup: as("admin@x.com", roleRentalAdmin)},
+			{Function: "set_status", Args: profile("", "admin@x.com", "", ""), WantErr: wantNotAllowed},
+			{Function: "review_driver", Args: []string{"d@x.com"}},
+			{Function: "set_status", Args: profile(statusUnderReview, "admin@x.com", "admin@x.com", ""), Setup: as("d@x.com"), Check: func(s *ledgertest.MemStub, res []byte) error {
+				if last := s.Events[len(s.Events)-1]; last.Name != eventDriverUpdated {
+					return fmt.Errorf("set_status emitted %s", last.Name)
+				}
+				return driverIs("d@x.com", statusUnderReview)(s, res)
+			}},
+			{Function: "set_status", Args: profile("", "admin@x.com", "admin@x.com", ""), Check: driverIs("d@x.com", statusUnderReview)},
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetStatusOnlyChangesTheDriversOwnFields(t *testing.T) {
+	profile := func(name, dl, dob, mobile, password string) []string {
+		return []string{"d@x.com", name, dl, dob, mobile, password, "addr", "", "", "", "", ""}
+	}
+	driver := Driver{}
+	_, err := ledgertest.Scenario{
+		Name:     "set_status fields",
+		Setup:    as("d@x.com"),
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			signedUp("d@x.com"),
+			{Function: "set_status", Args: profile("Other", "dl", "1990-01-01", "1", "pw"), WantErr: "can not update name"},
+			{Function: "set_status", Args: profile("D", "FORGED", "1990-01-01", "1", "pw"), WantErr: "can not update dl"},
+			{Function: "set_status", Args: profile("D", "dl", "1980-01-01", "1", "pw"), WantErr: "can not update dob"},
+			{Function: "set_status", Args: profile("D", "dl", "1990-01-01", "2", "secret"), Check: decode(driverKey("d@x.com"), &driver, func() error {
+				if driver.Name != "D" || driver.DL != "dl" || driver.DOB != "1990-01-01" || driver.Mobile != "2" || !driver.matches("secret") {
+					return fmt.Errorf("stored %+v", driver)
+				}
+				return nil
+			})},
+			{Function: "set_status", Args: profile("Other", "FORGED", "1980-01-01", "2", "secret"), Setup: as("admin@x.com", roleRentalAdmin), Check: decode(driverKey("d@x.com"), &driver, func() error {
+				if driver.Name != "Other" || driver.DL != "FORGED" || driver.DOB != "1980-01-01" {
+					return fmt.Errorf("stored %+v", driver)
+				}
+				return nil
+			})},
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// Error codes returned to clients. These are part of the chaincode's API,
+// clients branch on them, so never change the value of an existing code.
+const (
+	CodeInternal        = "INTERNAL"            //anything that is not one of the codes below
+	CodeUnknownFunction = "UNKNOWN_FUNCTION"    //no such Invoke or Query function
+	CodeArgCount        = "INCORRECT_ARG_COUNT" //wrong number of arguments
+	CodeInvalidArg      = "INVALID_ARGUMENT"    //an argument is empty, not numeric, badly formatted...
+	CodeValidation      = "VALIDATION_FAILED"   //the arguments do not match the function's schema, see Problems
+	CodeNotFound        = "NOT_FOUND"           //the marble, driver, trade... does not exist
+	CodeAlreadyExists   = "ALREADY_EXISTS"      //the marble, driver... already exists
+	CodeNotAllowed      = "NOT_ALLOWED"         //the caller may not do this, e.g. trade with themselves
+	CodeUnauthenticated = "UNAUTHENTICATED"     //the caller's certificate does not say who they are
+	CodeTradeMismatch   = "TRADE_REQUIREMENTS"  //the marbles offered do not satisfy the trade
+	CodeLedger          = "LEDGER_ERROR"        //reading or writing the world state failed
+	CodeCorruptRecord   = "CORRUPT_RECORD"      //a stored record could not be parsed
+	CodeConflict        = "REVISION_CONFLICT"   //the record changed since the caller read it, read it again
+)
+
+// NoArg is the Arg of an error that is not about a particular argument.
+const NoArg = -1
+
+// ChaincodeError is the error returned by every Invoke and Query function.
+// Its Error() string is the JSON form, so clients receive for example
+//
+//	{"code":"INVALID_ARGUMENT","message":"3rd argument must be a numeric string","arg":2,"function":"init_marble"}
+type ChaincodeError struct {
+	Code     string    `json:"code"`
+	Message  string    `json:"message"`
+	Arg      int       `json:"arg"`                //index of the offending argument, NoArg if none
+	Function string    `json:"function"`           //function that failed, filled in by Invoke and Query
+	Problems []Problem `json:"problems,omitempty"` //every problem found by checkArgs
+}
+
+func (e *ChaincodeError) Error() string {
+	jsonAsBytes, _ := json.Marshal(e)
+	return string(jsonAsBytes)
+}
+
+// newError - an error with a code that is not about a particular argument
+func newError(code string, msg string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: msg, Arg: NoArg}
+}
+
+// argError - an error about the argument at index i
+func argError(i int, msg string) *ChaincodeError {
+	return &ChaincodeError{Code: CodeInvalidArg, Message: msg, Arg: i}
+}
+
+// argCountError - the function was called with the wrong number of arguments
+func argCountError(expecting string) *ChaincodeError {
+	return newError(CodeArgCount, "Incorrect number of arguments. Expecting "+expecting)
+}
+
+// ledgerError - reading or writing the world state failed
+func ledgerError(msg string) *ChaincodeError {
+	return newError(CodeLedger, msg)
+}
+
+// hasCode - true if err is a ChaincodeError with this code
+func hasCode(err error, code string) bool {
+	ccErr, ok := err.(*ChaincodeError)
+	return ok && ccErr.Code == code
+}
+
+// ============================================================================================================================
+// toChaincodeError - stamp the function name on an error, wrapping errors that did not come from our handlers
+// ============================================================================================================================
+func toChaincodeError(function string, err error) error {
+	if err == nil {
+		return nil
+	}
+	ccErr, ok := err.(*ChaincodeError)
+	if !ok {
+		ccErr = newError(CodeInternal, err.Error())
+	}
+	if ccErr.Function == "" {
+		ccErr.Function = function
+	}
+	return ccErr
+}
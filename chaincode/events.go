@@ -0,0 +1,114 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Chaincode events. A transaction carries at most one event, the peer keeps
+// the last one set, so each Invoke function sets a single event once all of
+// its writes succeeded. The payload is the JSON of the matching *Event struct.
+// Event names are part of the chaincode's API, never rename one.
+const (
+	eventMarbleCreated        = "marble_created"
+	eventOwnerChanged         = "owner_changed"
+	eventMarbleDeleted        = "marble_deleted"
+	eventMarbleRestored       = "marble_restored"
+	eventMarblePurged         = "marble_purged"
+	eventTradeOpened          = "trade_opened"
+	eventTradeCompleted       = "trade_completed"
+	eventTradeRemoved         = "trade_removed"
+	eventDriverSignedUp       = "driver_signed_up"
+	eventDriverStatusChanged  = "driver_status_changed"
+	eventDriverUpdated        = "driver_updated"
+	eventCarBooked            = "car_booked"
+	eventBookingCancelled     = "booking_cancelled"
+	eventBookingStatusChanged = "booking_status_changed"
+	eventDriverDeleted        = "driver_deleted"
+	eventUserRegistered       = "user_registered"
+	eventUserDeactivated      = "user_deactivated"
+)
+
+// MarbleEvent is the payload of marble_created, marble_deleted, marble_restored and marble_purged
+type MarbleEvent struct {
+	Marble        Marble   `json:"marble"`
+	RemovedTrades []string `json:"removed_trades,omitempty"` //open trades marble_deleted left without an option, see cleanTrades
+}
+
+// OwnerEvent is the payload of owner_changed
+type OwnerEvent struct {
+	Marble        string   `json:"marble"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	RemovedTrades []string `json:"removed_trades,omitempty"` //open trades the move left without an option, see cleanTrades
+}
+
+// TradeEvent is the payload of trade_opened and trade_removed
+type TradeEvent struct {
+	Trade AnOpenTrade `json:"trade"`
+}
+
+// TradeCompletedEvent is the payload of trade_completed, the two marbles swapped owners
+type TradeCompletedEvent struct {
+	Trade        AnOpenTrade `json:"trade"`
+	Closer       string      `json:"closer"`        //user who closed the trade
+	OpenerMarble string      `json:"opener_marble"` //now owned by the closer
+	CloserMarble string      `json:"closer_marble"` //now owned by the opener
+
+	RemovedTrades []string `json:"removed_trades,omitempty"` //other open trades the swap left without an option, see cleanTrades
+}
+
+// DriverEvent is the payload of driver_signed_up, driver_status_changed, driver_updated and driver_deleted, never the password
+type DriverEvent struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Modifyby string `json:"modifyby"`
+}
+
+// UserEvent is the payload of user_registered and user_deactivated
+type UserEvent struct {
+	User User `json:"user"`
+}
+
+// BookingEvent is the payload of car_booked, booking_cancelled and booking_status_changed
+type BookingEvent struct {
+	Booking Bookcar `json:"booking"`
+}
+
+// ============================================================================================================================
+// emitEvent - set the chaincode event of this transaction
+// ============================================================================================================================
+func emitEvent(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+	jsonAsBytes, err := json.Marshal(payload)
+	if err != nil {
+		return newError(CodeInternal, "Failed to marshal event "+name)
+	}
+	err = stub.SetEvent(name, jsonAsBytes)
+	if err != nil {
+		return ledgerError("Failed to set event " + name)
+	}
+	return nil
+}
+
+func driverEvent(driver Driver) DriverEvent {
+	return DriverEvent{Email: driver.Email, Name: driver.Name, Status: driver.Status, Modifyby: driver.Modifyby}
+}
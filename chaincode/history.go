@@ -0,0 +1,111 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Every ownership change of a marble appends a HistoryEntry under
+// (marble name, tx time, tx id, n), so a range scan over the marble's name
+// returns its chain of custody oldest first. Entries are never rewritten or
+// removed, they outlive the marble itself.
+const (
+	nsMarbleHistory = "marble~history"
+	docHistory      = "history"
+)
+
+// Reasons an owner changed
+const (
+	reasonCreated     = "created"     //init_marble
+	reasonTransferred = "transferred" //set_user
+	reasonTraded      = "traded"      //perform_trade
+	reasonDeleted     = "deleted"     //delete retired the marble, To is empty
+	reasonRestored    = "restored"    //restore_marble, From is empty
+	reasonPurged      = "purged"      //purge_marble removed the retired marble, To is empty
+)
+
+// HistoryEntry is one ownership change of a marble
+type HistoryEntry struct {
+	Record
+	Marble    string `json:"marble"`
+	From      string `json:"from"`      //previous owner, empty when the marble was created
+	To        string `json:"to"`        //new owner, empty when the marble was deleted
+	TxID      string `json:"txid"`      //transaction that made the change
+	Timestamp int64  `json:"timestamp"` //utc timestamp of the transaction, in milliseconds
+	Reason    string `json:"reason"`    //one of the reason* constants
+	TradeId   string `json:"trade_id,omitempty"`
+}
+
+// ============================================================================================================================
+// appendHistory - record that marble name went from one owner to another in this transaction
+// ============================================================================================================================
+func (t *SimpleChaincode) appendHistory(stub shim.ChaincodeStubInterface, name string, from string, to string, reason string, tradeId string) error {
+	timestamp, err := t.makeTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	entry := HistoryEntry{Marble: name, From: from, To: to, TxID: stub.GetTxID(), Timestamp: timestamp, Reason: reason, TradeId: tradeId}
+
+	for n := 0; ; n++ { //a transaction can change the same marble more than once
+		key := makeKey(nsMarbleHistory, name, fmt.Sprintf("%020d", timestamp), entry.TxID, strconv.Itoa(n))
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return ledgerError("Failed to get " + printableKey(key))
+		}
+		if len(existing) == 0 {
+			return putRecord(stub, key, docHistory, &entry)
+		}
+	}
+}
+
+// ============================================================================================================================
+// Marble History - query, every ownership change of a marble, oldest first
+// ============================================================================================================================
+func (t *SimpleChaincode) marble_history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "name"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+
+	start := makeKey(nsMarbleHistory, args[0])
+	keysIter, err := stub.RangeQueryState(start, start+lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan history of " + args[0])
+	}
+	defer keysIter.Close()
+
+	history := []HistoryEntry{}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan history of " + args[0])
+		}
+		entry := HistoryEntry{}
+		if json.Unmarshal(valAsBytes, &entry) != nil {
+			return nil, newError(CodeCorruptRecord, "Stored "+printableKey(key)+" is corrupt")
+		}
+		history = append(history, entry)
+	}
+	return json.Marshal(history)
+}
@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Secondary indexes of marbles. Each entry is a composite key ending in the
+// marble name with a placeholder value, so the marbles matching an owner, or a color and
+// size, are found with one range scan. Entries are written and deleted in the
+// same transaction as the marble, keep them in step with indexMarble and
+// unindexMarble whenever a marble is created, changed or removed.
+const (
+	nsMarbleByOwner = "marble~owner"      //owner, name
+	nsMarbleByColor = "marble~color~size" //color, size, name
+	nsMarbleBySize  = "marble~size"       //size, name
+)
+
+// marbleIndexes are the namespaces of every marble index
+var marbleIndexes = []string{nsMarbleByOwner, nsMarbleByColor, nsMarbleBySize}
+
+// secondaryIndexes are the namespaces of every index, of marbles and of bookings
+var secondaryIndexes = append(append([]string{}, marbleIndexes...), bookingIndexes...)
+
+// indexValue is stored under every index key, the key itself is the data
+var indexValue = []byte{0x00}
+
+// isIndexKey - true for an entry of one of the secondary indexes
+func isIndexKey(key string) bool {
+	ns, _, ok := splitKey(key)
+	if !ok {
+		return false
+	}
+	for _, index := range secondaryIndexes {
+		if ns == index {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeKey - size as a fixed width string that sorts in numeric order, negative sizes included
+func sizeKey(size int) string {
+	return fmt.Sprintf("%020d", uint64(int64(size))^(1<<63))
+}
+
+// marbleIndexKeys - every index key of a marble
+func marbleIndexKeys(marble Marble) []string {
+	return []string{
+		makeKey(nsMarbleByOwner, strings.ToLower(marble.User), marble.Name),
+		makeKey(nsMarbleByColor, strings.ToLower(marble.Color), sizeKey(marble.Size), marble.Name),
+		makeKey(nsMarbleBySize, sizeKey(marble.Size), marble.Name),
+	}
+}
+
+// indexMarble - add a marble to the secondary indexes
+func indexMarble(stub shim.ChaincodeStubInterface, marble Marble) error {
+	for _, key := range marbleIndexKeys(marble) {
+		if err := stub.PutState(key, indexValue); err != nil {
+			return ledgerError("Failed to write index " + printableKey(key))
+		}
+	}
+	return nil
+}
+
+// unindexMarble - remove a marble from the secondary indexes
+func unindexMarble(stub shim.ChaincodeStubInterface, marble Marble) error {
+	for _, key := range marbleIndexKeys(marble) {
+		if err := stub.DelState(key); err != nil {
+			return ledgerError("Failed to delete index " + printableKey(key))
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// scanIndex - call fn with the marble name of every entry of index ns under these leading parts, until fn returns false
+// ============================================================================================================================
+func scanIndex(stub shim.ChaincodeStubInterface, ns string, prefix []string, fn func(name string) (bool, error)) error {
+	start, end := namespaceRange(ns)
+	if len(prefix) > 0 {
+		start = makeKey(ns, prefix...)
+		end = start + lastKey
+	}
+	keysIter, err := stub.RangeQueryState(start, end)
+	if err != nil {
+		return ledgerError("Failed to scan index " + ns)
+	}
+	defer keysIter.Close()
+
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return ledgerError("Failed to scan index " + ns)
+		}
+		_, parts, ok := splitKey(key)
+		if !ok || len(parts) == 0 {
+			continue
+		}
+		more, err := fn(parts[len(parts)-1])
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Rebuild Indexes - drop every secondary index entry and index each marble and booking again, for ledgers written
+// before the indexes existed or after a repair
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuild_indexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start rebuild indexes")
+	for _, ns := range secondaryIndexes {
+		var stale []string
+		start, end := namespaceRange(ns)
+		keysIter, err := stub.RangeQueryState(start, end)
+		if err != nil {
+			return nil, ledgerError("Failed to scan index " + ns)
+		}
+		for keysIter.HasNext() {
+			key, _, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return nil, ledgerError("Failed to scan index " + ns)
+			}
+			stale = append(stale, key)
+		}
+		keysIter.Close()
+		for _, key := range stale {
+			if err := stub.DelState(key); err != nil {
+				return nil, ledgerError("Failed to delete index " + printableKey(key))
+			}
+		}
+	}
+
+	var marbles []Marble
+	err := scanMarbles(stub, "", func(marble Marble) bool {
+		marbles = append(marbles, marble)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, marble := range marbles {
+		if err := indexMarble(stub, marble); err != nil {
+			return nil, err
+		}
+	}
+
+	bookings, err := reindexBookings(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end rebuild indexes, indexed " + strconv.Itoa(len(marbles)) + " marbles and " + strconv.Itoa(bookings) + " bookings")
+	return json.Marshal(map[string]int{"indexed": len(marbles), "bookings": bookings})
+}
@@ -0,0 +1,212 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Records live under composite keys, "\x00" + namespace + "\x00" + each id
+// part + "\x00", the same layout later fabric releases use for
+// CreateCompositeKey. A marble named like a driver's email can no longer
+// overwrite the driver, and every record of a namespace sorts together so it
+// can be range scanned. The flat keyspace is left to system keys, the "_"
+// indexes, and to scratch values such as "abc" set with write. Arguments can
+// not contain "\x00", see checkArg, so an id can never escape its namespace.
+const (
+	keySep = "\x00"
+
+	nsMarble  = "marble"
+	nsDriver  = "driver"
+	nsBooking = "booking"
+	nsAdmin   = "admin"
+)
+
+// protectedPrefixes are the keys write refuses to touch: the "_" system
+// records, such as _opentrades and _driverindex, and every namespaced record,
+// which only its own Invoke functions may change.
+var protectedPrefixes = []string{"_", keySep}
+
+// isProtectedKey - true when key starts with one of the protectedPrefixes
+func isProtectedKey(key string) bool {
+	for _, prefix := range protectedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// makeKey - the composite key of a record in namespace ns with these id parts
+func makeKey(ns string, parts ...string) string {
+	return keySep + ns + keySep + strings.Join(parts, keySep) + keySep
+}
+
+func marbleKey(name string) string {
+	return makeKey(nsMarble, name)
+}
+
+func driverKey(email string) string {
+	return makeKey(nsDriver, email)
+}
+
+func bookingKey(email string, bookingid string) string {
+	return makeKey(nsBooking, email, bookingid)
+}
+
+func adminKey(userid string) string {
+	return makeKey(nsAdmin, userid)
+}
+
+// namespaceRange - start and end keys of a range scan over every record in ns
+func namespaceRange(ns string) (string, string) {
+	return keySep + ns + keySep, keySep + ns + keySep + lastKey
+}
+
+// splitKey - the namespace and id parts of a composite key, ok is false for a flat key
+func splitKey(key string) (ns string, parts []string, ok bool) {
+	if !strings.HasPrefix(key, keySep) || !strings.HasSuffix(key, keySep) || len(key) < 3 {
+		return "", nil, false
+	}
+	fields := strings.Split(key[1:len(key)-1], keySep)
+	return fields[0], fields[1:], true
+}
+
+// printableKey - a key as shown in messages and reports, e.g. "driver/bob@example.com"
+func printableKey(key string) string {
+	ns, parts, ok := splitKey(key)
+	if !ok {
+		return key
+	}
+	return ns + "/" + strings.Join(parts, "/")
+}
+
+// ============================================================================================================================
+// Migrate Keys - move records stored under legacy flat keys to their namespaced keys
+//
+// Marbles used to be keyed by name, drivers by email, bookings by email+bookingid and the admin by userid. Each flat
+// record is classified, written under its composite key and deleted from the flat keyspace. A record whose namespaced
+// key is already taken is left where it is and reported as a conflict, records that do not parse are reported as
+// skipped, run repair_records on those first. Moved marbles and bookings are added to the secondary indexes, run
+// rebuild_indexes afterwards to list each driver's bookings on the driver.
+// ============================================================================================================================
+func (t *SimpleChaincode) migrate_keys(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start migrate keys")
+	report := KeyMigrationReport{Moved: map[string]string{}}
+
+	keysIter, err := stub.RangeQueryState("", lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan keys")
+	}
+	defer keysIter.Close()
+
+	type move struct {
+		from, to string
+		docType  string
+		value    []byte
+	}
+	var moves []move
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan keys")
+		}
+		if isSystemKey(key) || strings.HasPrefix(key, keySep) {
+			continue //not a record, or already namespaced
+		}
+
+		var fields map[string]interface{}
+		if json.Unmarshal(valAsBytes, &fields) != nil || fields == nil {
+			report.Skipped = append(report.Skipped, key)
+			continue
+		}
+		docType, _ := fields["docType"].(string)
+		if docType == "" {
+			_, docType = classifyRecord(fields)
+		}
+
+		var newKey string
+		switch docType {
+		case docMarble:
+			newKey = marbleKey(key)
+		case docDriver:
+			newKey = driverKey(key)
+		case docAdmin:
+			newKey = adminKey(key)
+		case docBooking:
+			booking := Bookcar{}
+			json.Unmarshal(valAsBytes, &booking)
+			if booking.Bookacaremail+booking.Bookingid != key {
+				report.Skipped = append(report.Skipped, key) //can not tell where the email ends
+				continue
+			}
+			newKey = bookingKey(booking.Bookacaremail, booking.Bookingid)
+		default:
+			report.Skipped = append(report.Skipped, key)
+			continue
+		}
+		moves = append(moves, move{key, newKey, docType, valAsBytes})
+	}
+
+	for _, m := range moves { //the scan is done, now rewrite
+		existing, err := stub.GetState(m.to)
+		if err != nil {
+			return nil, ledgerError("Failed to get " + printableKey(m.to))
+		}
+		if len(existing) > 0 {
+			fmt.Println("! " + m.from + " conflicts with " + printableKey(m.to))
+			report.Conflicts = append(report.Conflicts, m.from)
+			continue
+		}
+		if err := stub.PutState(m.to, m.value); err != nil {
+			return nil, ledgerError("Failed to write " + printableKey(m.to))
+		}
+		if err := stub.DelState(m.from); err != nil {
+			return nil, ledgerError("Failed to delete " + m.from)
+		}
+		if m.docType == docMarble {
+			marble := Marble{}
+			json.Unmarshal(m.value, &marble)
+			if err := indexMarble(stub, marble); err != nil {
+				return nil, err
+			}
+		} else if m.docType == docBooking {
+			booking := Bookcar{}
+			json.Unmarshal(m.value, &booking)
+			if err := indexBooking(stub, booking); err != nil {
+				return nil, err
+			}
+		}
+		report.Moved[m.from] = printableKey(m.to)
+	}
+
+	fmt.Println("- end migrate keys, moved " + strconv.Itoa(len(report.Moved)))
+	return json.Marshal(report)
+}
+
+// KeyMigrationReport is the result of migrate_keys
+type KeyMigrationReport struct {
+	Moved     map[string]string `json:"moved"`     //new key of every record moved, by old key
+	Conflicts []string          `json:"conflicts"` //flat keys whose namespaced key was already taken
+	Skipped   []string          `json:"skipped"`   //flat keys that are not a record this chaincode knows
+}
@@ -0,0 +1,163 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// flatLedger - a ledger as the first version of the chaincode left it, every record under its bare id
+func flatLedger() *ledgertest.MemStub {
+	s := ledgertest.NewMemStub()
+	asOperator(s)
+	for key, value := range map[string]string{
+		"m1":            legacyMarble("m1", "blue", 16, "bob"),
+		"m2":            `{"name":"m2","color":"red","size":35,"user":"amy"}`, //rewritten by set_user with json.Marshal
+		"m3":            legacyMarble("m3", "green", 5, "bob"),
+		marbleKey("m3"): marbleState("m3", "green", 5, "amy"),
+		"d@x.com":       `{"name":"D","dl":"dl","dob":"1990-01-01","email":"d@x.com","mobile":"1","password":"pw","address":"addr","status":"","modifyby":"","adminemail":"","rejectreason":"","anycomment":"","bookingid":"b1"}`,
+		"d@x.comb1":     legacyBooking("D", "d@x.com", "suv", "Airport", "there", "2016-02-01", "10:00", "2016-02-02", "10:00", "b1"),
+		"admin@x.com":   legacyAdmin("admin@x.com", "pw"),
+		"scratch":       "hello",
+		"abc":           "42",
+		"_marbleindex":  `["m1","m2","m3"]`,
+		"_driverindex":  `["d@x.com","b1"]`,
+		"_opentrades":   `{"open_trades":[]}`,
+	} {
+		s.State[key] = []byte(value)
+	}
+	return s
+}
+
+func TestMigrateKeys(t *testing.T) {
+	s := flatLedger()
+	cc := new(SimpleChaincode)
+	legacy := map[string][]byte{}
+	for _, key := range s.Keys() {
+		legacy[key] = s.Get(key)
+	}
+
+	res, err := s.Invoke(cc, "migrate_keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := KeyMigrationReport{
+		Moved: map[string]string{
+			"m1":          printableKey(marbleKey("m1")),
+			"m2":          printableKey(marbleKey("m2")),
+			"d@x.com":     printableKey(driverKey("d@x.com")),
+			"d@x.comb1":   printableKey(bookingKey("d@x.com", "b1")),
+			"admin@x.com": printableKey(adminKey("admin@x.com")),
+		},
+		Conflicts: []string{"m3"},
+		Skipped:   []string{"scratch"},
+	}
+	var got KeyMigrationReport
+	if json.Unmarshal(res, &got) != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("report %s, want %+v", res, want)
+	}
+
+	state := map[string]string{
+		"m1": "", "m2": "", "d@x.com": "", "d@x.comb1": "", "admin@x.com": "",
+		"m3":                                  string(legacy["m3"]), //left for an operator to sort out
+		marbleKey("m3"):                       string(legacy[marbleKey("m3")]),
+		"scratch":                             "hello",
+		"abc":                                 "42",
+		marbleKey("m1"):                       string(legacy["m1"]),
+		marbleKey("m2"):                       string(legacy["m2"]),
+		driverKey("d@x.com"):                  string(legacy["d@x.com"]),
+		bookingKey("d@x.com", "b1"):           string(legacy["d@x.comb1"]),
+		adminKey("admin@x.com"):               string(legacy["admin@x.com"]),
+		makeKey(nsMarbleByOwner, "bob", "m1"): string(indexValue),
+		makeKey(nsBookingByDate, "2016-02-01", "d@x.com", "b1"): string(indexValue),
+	}
+	if err := ledgertest.CheckState(s, state); err != nil {
+		t.Fatal(err)
+	}
+	for function, args := range map[string][]string{
+		"read_marble":      {"m1"},
+		"marbles_by_color": {"red"},
+		"get_booking":      {"d@x.com", "b1"},
+	} {
+		if res, err := s.Query(cc, function, args); err != nil || len(res) == 0 {
+			t.Errorf("%s after the migration: %s %v", function, res, err)
+		}
+	}
+
+	//a second run moves nothing
+	before := map[string]string{}
+	for _, key := range s.Keys() {
+		before[key] = string(s.Get(key))
+	}
+	res, err = s.Invoke(cc, "migrate_keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = KeyMigrationReport{}
+	json.Unmarshal(res, &got)
+	if len(got.Moved) != 0 || !reflect.DeepEqual(got.Conflicts, want.Conflicts) || !reflect.DeepEqual(got.Skipped, want.Skipped) {
+		t.Errorf("second run %s", res)
+	}
+	if err := ledgertest.CheckState(s, before); err != nil || len(s.Keys()) != len(before) {
+		t.Errorf("second run changed the ledger: %v", err)
+	}
+}
+
+func TestWriteRefusesProtectedKeys(t *testing.T) {
+	cc := new(SimpleChaincode)
+	s, err := ledgertest.Scenario{
+		Name:     "write",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "write", Args: []string{openTradesStr, "{}"}, WantErr: `"code":"NOT_ALLOWED"`},
+			ledgertest.Step{Function: "write", Args: []string{"_anything", "1"}, WantErr: `"code":"NOT_ALLOWED"`},
+			ledgertest.Step{Function: "write", Args: []string{marbleKey("m1"), "{}"}, WantErr: `"code":"VALIDATION_FAILED"`}, //the schema refuses NUL
+			ledgertest.Step{Function: "write", Args: []string{"abc", "43"}},
+		),
+		State: map[string]string{
+			openTradesStr:   `{"open_trades":[]}`,
+			"_anything":     "",
+			marbleKey("m1"): marbleState("m1", "blue", 16, "bob"),
+			"abc":           "43",
+		},
+	}.Run(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Write checks the key itself too, in case a schema ever lets one through
+	for _, key := range []string{marbleKey("m1"), driverKey("d@x.com"), makeKey(nsMarbleByOwner, "bob", "m1"), keySep} {
+		s.Begin("write", []string{key, "{}"}, false)
+		_, err := cc.Write(s, []string{key, "{}"})
+		s.Rollback()
+		if !hasCode(err, CodeNotAllowed) {
+			t.Errorf("write %s: got %v", printableKey(key), err)
+		}
+	}
+	for key, protected := range map[string]bool{"_": true, "_marbleindex": true, "abc": false, "m1": false, "a_b": false, "": false} {
+		if isProtectedKey(key) != protected {
+			t.Errorf("isProtectedKey(%q) = %v", key, !protected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledgertest
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// FakeClock is a clock for the chaincode that ignores the transaction and
+// always reports T. Tests move it with Set and Advance.
+//
+//	clock := ledgertest.NewFakeClock(ledgertest.Epoch)
+//	cc := &SimpleChaincode{clock: clock}
+type FakeClock struct {
+	T time.Time
+}
+
+// NewFakeClock returns a clock stopped at t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{T: t}
+}
+
+// Now returns the time the clock is stopped at.
+func (c *FakeClock) Now(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	return c.T, nil
+}
+
+// Set stops the clock at t.
+func (c *FakeClock) Set(t time.Time) {
+	c.T = t
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.T = c.T.Add(d)
+}
@@ -0,0 +1,396 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package ledgertest provides an in-memory implementation of
+// shim.ChaincodeStubInterface and a scenario runner that replays sequences
+// of Init/Invoke/Query calls against a chaincode and checks the resulting
+// world state. It lets the marbles chaincode be exercised without a peer.
+package ledgertest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/attr"
+)
+
+// Epoch is the transaction time of the first transaction run on a new MemStub.
+var Epoch = time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Event is a chaincode event committed by a successful transaction.
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+// MemStub is an in-memory ledger. Writes made during a transaction are
+// buffered and only reach State when the transaction is committed, so a
+// chaincode function that returns an error leaves the ledger untouched, the
+// same as on a real peer.
+type MemStub struct {
+	State  map[string][]byte // committed world state
+	Events []Event           // committed chaincode events, oldest first
+
+	CallerCert     []byte            // returned by GetCallerCertificate
+	CallerMetadata []byte            // returned by GetCallerMetadata
+	Attributes     map[string][]byte // certificate attributes returned by ReadCertAttribute
+
+	Now  time.Time     // transaction time of the next transaction
+	Tick time.Duration // how far Now advances after each transaction
+
+	txID     string
+	txSeq    int
+	args     []string
+	readOnly bool
+	inTx     bool
+	writes   map[string][]byte // pending writes, nil value means delete
+	event    *Event
+}
+
+// NewMemStub returns an empty ledger whose first transaction runs at Epoch.
+func NewMemStub() *MemStub {
+	return &MemStub{
+		State:      map[string][]byte{},
+		Attributes: map[string][]byte{},
+		Now:        Epoch,
+		Tick:       time.Second,
+	}
+}
+
+// Begin starts a new transaction. Query transactions are read only.
+func (s *MemStub) Begin(function string, args []string, query bool) {
+	s.txSeq++
+	s.txID = fmt.Sprintf("tx%06d", s.txSeq)
+	s.args = append([]string{function}, args...)
+	s.readOnly = query
+	s.inTx = true
+	s.writes = map[string][]byte{}
+	s.event = nil
+}
+
+// Commit applies the pending writes and event of the current transaction.
+func (s *MemStub) Commit() {
+	for k, v := range s.writes {
+		if v == nil {
+			delete(s.State, k)
+		} else {
+			s.State[k] = v
+		}
+	}
+	if s.event != nil {
+		s.Events = append(s.Events, *s.event)
+	}
+	s.end()
+}
+
+// Rollback discards the pending writes and event of the current transaction.
+func (s *MemStub) Rollback() {
+	s.end()
+}
+
+func (s *MemStub) end() {
+	s.writes = nil
+	s.event = nil
+	s.inTx = false
+	s.Now = s.Now.Add(s.Tick)
+}
+
+// Init runs cc.Init as a single transaction, committing it on success.
+func (s *MemStub) Init(cc shim.Chaincode, function string, args []string) ([]byte, error) {
+	s.Begin(function, args, false)
+	return s.finish(cc.Init(s, function, args))
+}
+
+// Invoke runs cc.Invoke as a single transaction, committing it on success.
+func (s *MemStub) Invoke(cc shim.Chaincode, function string, args []string) ([]byte, error) {
+	s.Begin(function, args, false)
+	return s.finish(cc.Invoke(s, function, args))
+}
+
+// Query runs cc.Query as a read only transaction.
+func (s *MemStub) Query(cc shim.Chaincode, function string, args []string) ([]byte, error) {
+	s.Begin(function, args, true)
+	return s.finish(cc.Query(s, function, args))
+}
+
+func (s *MemStub) finish(res []byte, err error) ([]byte, error) {
+	if err != nil {
+		s.Rollback()
+		return res, err
+	}
+	s.Commit()
+	return res, nil
+}
+
+// SetCaller sets the username and role certificate attributes of the caller
+// of the following transactions. An empty username removes both.
+func (s *MemStub) SetCaller(username string, roles ...string) {
+	delete(s.Attributes, "username")
+	delete(s.Attributes, "role")
+	if username == "" {
+		return
+	}
+	s.Attributes["username"] = []byte(username)
+	if len(roles) > 0 {
+		s.Attributes["role"] = []byte(strings.Join(roles, ","))
+	}
+}
+
+// Get returns the committed value of key, or nil if it is not set.
+func (s *MemStub) Get(key string) []byte {
+	return s.State[key]
+}
+
+// Keys returns every committed key in lexical order.
+func (s *MemStub) Keys() []string {
+	keys := make([]string, 0, len(s.State))
+	for k := range s.State {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ============================================================================================================================
+// shim.ChaincodeStubInterface
+// ============================================================================================================================
+
+func (s *MemStub) GetArgs() [][]byte {
+	args := make([][]byte, len(s.args))
+	for i, a := range s.args {
+		args[i] = []byte(a)
+	}
+	return args
+}
+
+func (s *MemStub) GetStringArgs() []string {
+	return append([]string(nil), s.args...)
+}
+
+func (s *MemStub) GetTxID() string {
+	return s.txID
+}
+
+func (s *MemStub) InvokeChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return nil, errors.New("ledgertest: InvokeChaincode is not supported")
+}
+
+func (s *MemStub) QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return nil, errors.New("ledgertest: QueryChaincode is not supported")
+}
+
+func (s *MemStub) GetState(key string) ([]byte, error) {
+	if v, ok := s.writes[key]; ok {
+		return v, nil
+	}
+	return s.State[key], nil
+}
+
+func (s *MemStub) PutState(key string, value []byte) error {
+	if err := s.writable(key); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	s.writes[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemStub) DelState(key string) error {
+	if err := s.writable(key); err != nil {
+		return err
+	}
+	s.writes[key] = nil
+	return nil
+}
+
+func (s *MemStub) writable(key string) error {
+	if !s.inTx {
+		return errors.New("ledgertest: write outside of a transaction")
+	}
+	if s.readOnly {
+		return errors.New("ledgertest: cannot write " + key + " during a query")
+	}
+	if key == "" {
+		return errors.New("ledgertest: empty key")
+	}
+	return nil
+}
+
+// RangeQueryState returns the keys between startKey and endKey, both
+// inclusive, in lexical order. Pending writes of the current transaction
+// are visible to the scan.
+func (s *MemStub) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	merged := map[string][]byte{}
+	for k, v := range s.State {
+		merged[k] = v
+	}
+	for k, v := range s.writes {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	it := &rangeIterator{}
+	for k, v := range merged {
+		if k >= startKey && k <= endKey {
+			it.keys = append(it.keys, k)
+			it.values = append(it.values, v)
+		}
+	}
+	sort.Sort(it)
+	return it, nil
+}
+
+func (s *MemStub) CreateTable(name string, columnDefinitions []*shim.ColumnDefinition) error {
+	return errTables
+}
+
+func (s *MemStub) GetTable(tableName string) (*shim.Table, error) {
+	return nil, errTables
+}
+
+func (s *MemStub) DeleteTable(tableName string) error {
+	return errTables
+}
+
+func (s *MemStub) InsertRow(tableName string, row shim.Row) (bool, error) {
+	return false, errTables
+}
+
+func (s *MemStub) ReplaceRow(tableName string, row shim.Row) (bool, error) {
+	return false, errTables
+}
+
+func (s *MemStub) GetRow(tableName string, key []shim.Column) (shim.Row, error) {
+	return shim.Row{}, errTables
+}
+
+func (s *MemStub) GetRows(tableName string, key []shim.Column) (<-chan shim.Row, error) {
+	return nil, errTables
+}
+
+func (s *MemStub) DeleteRow(tableName string, key []shim.Column) error {
+	return errTables
+}
+
+var errTables = errors.New("ledgertest: tables are not supported")
+
+func (s *MemStub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	v, ok := s.Attributes[attributeName]
+	if !ok {
+		return nil, errors.New("ledgertest: no attribute " + attributeName)
+	}
+	return v, nil
+}
+
+func (s *MemStub) VerifyAttribute(attributeName string, attributeValue []byte) (bool, error) {
+	v, err := s.ReadCertAttribute(attributeName)
+	if err != nil {
+		return false, err
+	}
+	return string(v) == string(attributeValue), nil
+}
+
+func (s *MemStub) VerifyAttributes(attrs ...*attr.Attribute) (bool, error) {
+	for _, a := range attrs {
+		ok, err := s.VerifyAttribute(a.Name, a.Value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *MemStub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	return false, errors.New("ledgertest: VerifySignature is not supported")
+}
+
+func (s *MemStub) GetCallerCertificate() ([]byte, error) {
+	return s.CallerCert, nil
+}
+
+func (s *MemStub) GetCallerMetadata() ([]byte, error) {
+	return s.CallerMetadata, nil
+}
+
+func (s *MemStub) GetBinding() ([]byte, error) {
+	return []byte(s.txID), nil
+}
+
+func (s *MemStub) GetPayload() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *MemStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.Now.Unix(), Nanos: int32(s.Now.Nanosecond())}, nil
+}
+
+// SetEvent records the event for the current transaction. As on a peer,
+// only the last event set by a transaction is kept.
+func (s *MemStub) SetEvent(name string, payload []byte) error {
+	if !s.inTx {
+		return errors.New("ledgertest: event outside of a transaction")
+	}
+	if name == "" {
+		return errors.New("ledgertest: event name can not be empty")
+	}
+	s.event = &Event{TxID: s.txID, Name: name, Payload: append([]byte(nil), payload...)}
+	return nil
+}
+
+// ============================================================================================================================
+// rangeIterator - shim.StateRangeQueryIteratorInterface over a sorted snapshot
+// ============================================================================================================================
+type rangeIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func (it *rangeIterator) Len() int           { return len(it.keys) }
+func (it *rangeIterator) Less(i, j int) bool { return it.keys[i] < it.keys[j] }
+func (it *rangeIterator) Swap(i, j int) {
+	it.keys[i], it.keys[j] = it.keys[j], it.keys[i]
+	it.values[i], it.values[j] = it.values[j], it.values[i]
+}
+
+func (it *rangeIterator) HasNext() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *rangeIterator) Next() (string, []byte, error) {
+	if !it.HasNext() {
+		return "", nil, errors.New("ledgertest: iterator exhausted")
+	}
+	k, v := it.keys[it.pos], it.values[it.pos]
+	it.pos++
+	return k, v, nil
+}
+
+func (it *rangeIterator) Close() error {
+	it.pos = len(it.keys)
+	return nil
+}
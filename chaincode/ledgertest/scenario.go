@@ -0,0 +1,178 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledgertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Step is a single Invoke or Query call made by a Scenario.
+type Step struct {
+	Name     string   // label used in failure messages, defaults to the function name
+	Query    bool     // run through Query instead of Invoke
+	Function string   // chaincode function to call
+	Args     []string // arguments passed to the function
+
+	// WantErr is a substring the returned error must contain. When empty the
+	// call must succeed.
+	WantErr string
+
+	// Want is the expected response. It is compared as JSON when both sides
+	// parse as JSON and byte for byte otherwise. Empty skips the comparison.
+	Want string
+
+	// Setup, when set, runs before the call, e.g. to change the caller.
+	Setup func(s *MemStub)
+
+	// Check, when set, runs after the call with the response.
+	Check func(s *MemStub, res []byte) error
+}
+
+// Scenario replays a sequence of calls against a fresh ledger.
+//
+//	sc := ledgertest.Scenario{
+//		Name:     "trade",
+//		Setup:    func(s *ledgertest.MemStub) { s.SetCaller("admin", "operator") },
+//		InitArgs: []string{"admin@example.com", "pw"},
+//		Steps: []ledgertest.Step{
+//			{Function: "register_user", Args: []string{"bob"}},
+//			{Function: "register_user", Args: []string{"leroy"}},
+//			{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+//			{Function: "init_marble", Args: []string{"m2", "red", "35", "leroy"}},
+//			{Function: "open_trade", Args: []string{"bob", "red", "35", "blue", "16"}},
+//		},
+//		State: map[string]string{"\x00marble\x00m1\x00": `{"docType":"marble","version":1,"name":"m1","color":"blue","size":16,"user":"bob"}`},
+//	}
+//	if _, err := sc.Run(new(SimpleChaincode)); err != nil {
+//		t.Fatal(err)
+//	}
+type Scenario struct {
+	Name     string
+	Function string   // Init function name, defaults to "init"
+	InitArgs []string // arguments to Init; nil skips Init
+	Steps    []Step
+
+	// State lists the committed values expected once every step has run,
+	// compared the same way as Step.Want. An empty value asserts the key is
+	// absent.
+	State map[string]string
+
+	// Setup, when set, runs on the new ledger before Init.
+	Setup func(s *MemStub)
+}
+
+// Run executes the scenario against cc on a new MemStub and returns the
+// ledger together with the first failure found, if any.
+func (sc Scenario) Run(cc shim.Chaincode) (*MemStub, error) {
+	s := NewMemStub()
+	if sc.Setup != nil {
+		sc.Setup(s)
+	}
+
+	if sc.InitArgs != nil {
+		function := sc.Function
+		if function == "" {
+			function = "init"
+		}
+		if _, err := s.Init(cc, function, sc.InitArgs); err != nil {
+			return s, sc.fail("init", "unexpected error: %s", err)
+		}
+	}
+
+	for i, step := range sc.Steps {
+		label := step.Name
+		if label == "" {
+			label = fmt.Sprintf("step %d (%s)", i, step.Function)
+		}
+		if step.Setup != nil {
+			step.Setup(s)
+		}
+
+		var res []byte
+		var err error
+		if step.Query {
+			res, err = s.Query(cc, step.Function, step.Args)
+		} else {
+			res, err = s.Invoke(cc, step.Function, step.Args)
+		}
+
+		switch {
+		case step.WantErr == "" && err != nil:
+			return s, sc.fail(label, "unexpected error: %s", err)
+		case step.WantErr != "" && err == nil:
+			return s, sc.fail(label, "expected error containing %q, got none", step.WantErr)
+		case step.WantErr != "" && !strings.Contains(err.Error(), step.WantErr):
+			return s, sc.fail(label, "expected error containing %q, got %q", step.WantErr, err.Error())
+		}
+		if step.Want != "" && !Equal(res, []byte(step.Want)) {
+			return s, sc.fail(label, "response mismatch\n got: %s\nwant: %s", res, step.Want)
+		}
+		if step.Check != nil {
+			if err := step.Check(s, res); err != nil {
+				return s, sc.fail(label, "%s", err)
+			}
+		}
+	}
+
+	if err := CheckState(s, sc.State); err != nil {
+		return s, sc.fail("final state", "%s", err)
+	}
+	return s, nil
+}
+
+func (sc Scenario) fail(where string, format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s: %s", sc.Name, where, fmt.Sprintf(format, a...))
+}
+
+// CheckState compares the committed state of s against want. An empty
+// expected value asserts that the key is absent.
+func CheckState(s *MemStub, want map[string]string) error {
+	for key, expected := range want {
+		got, ok := s.State[key]
+		if expected == "" {
+			if ok {
+				return fmt.Errorf("key %q should be absent, has %s", key, got)
+			}
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("key %q is missing, want %s", key, expected)
+		}
+		if !Equal(got, []byte(expected)) {
+			return fmt.Errorf("key %q mismatch\n got: %s\nwant: %s", key, got, expected)
+		}
+	}
+	return nil
+}
+
+// Equal reports whether a and b hold the same JSON document, ignoring
+// formatting and key order. Values that are not both JSON are compared
+// byte for byte.
+func Equal(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(x, y)
+}
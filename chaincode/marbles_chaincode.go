@@ -18,11 +18,9 @@ under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"encoding/json"
-	"time"
 	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -30,36 +28,45 @@ import (
 
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
+	clock Clock									//source of time for ledger writes, nil means the transaction timestamp
 }
 
-var marbleIndexStr = "_marbleindex"	
+var marbleIndexStr = "_marbleindex"			//no longer stored, read builds it from the marbles, see marbleIndexView
 var driverIndexStr = "_driverindex"				//name for the key/value that will store a list of all known marbles
 var openTradesStr = "_opentrades"				//name for the key/value that will store all open trades
 
 type Marble struct{
+	Record													//docType and version, see records.go
 	Name string `json:"name"`					//the fieldtags are needed to keep case from bouncing around
 	Color string `json:"color"`
 	Size int `json:"size"`
 	User string `json:"user"`
+	Retired *Retirement `json:"retired,omitempty"`		//set when the marble is deleted, see retire.go
 }
 
 type Driver struct{
+	Record
 	Name string `json:"name"`
 	DL string `json:"dl"`		
 	DOB string `json:"dob"`	
 	Email string `json:"email"`
 	Mobile string `json:"mobile"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`	//legacy clear text, hashed by migrate_passwords
+	Passwordhash string `json:"passwordhash,omitempty"`	//salted hash, see credentials.go
 	Address string `json:"address"`
 	Status string `json:"status"`
 	Modifyby string `json:"modifyby"`
 	Adminemail string `json:"adminemail"`
 	Rejectreason string `json:"rejectreason"`
 	Anycomment string `json:"anycomment"`
-	Bookingid  string `json:"bookingid"`
+	Bookingid  string `json:"bookingid"`			//latest booking, see Bookings
+	Bookings []string `json:"bookings,omitempty"`	//ids of every booking of the driver, oldest first
+	Statushistory []StatusChange `json:"statushistory,omitempty"`	//every status change, see drivers.go
+	Revision int `json:"revision"`				//bumped on every write, update_driver checks it
 }
 
 type Bookcar struct{
+	Record
 	Bookacarname string `json:"bookacarname"`
 	Bookacaremail string `json:"bookacaremail"`		
 	Bookacarclass string `json:"bookacarclass"`
@@ -70,6 +77,11 @@ type Bookcar struct{
 	Bookacardropoffdate string `json:"bookacardropoffdate"`
 	Bookacardropofftime string `json:"bookacardropofftime"`
 	Bookingid string `json:"bookingid"`
+	Timestamp int64 `json:"timestamp"`				//utc timestamp of when the booking was made
+	Pickupat int64 `json:"pickupat"`					//utc timestamp of the pickup date and time, see bookingInstant
+	Dropoffat int64 `json:"dropoffat"`				//utc timestamp of the dropoff date and time
+	Status string `json:"status"`						//see the booking lifecycle in bookings.go
+	Statushistory []StatusChange `json:"statushistory,omitempty"`	//every move through the lifecycle, starting with the booking itself
 }
 
 
@@ -79,8 +91,9 @@ type Description struct{
 }
 
 type AnOpenTrade struct{
+	Id string `json:"id"`						//unique id of the trade, derived from the creating transaction
 	User string `json:"user"`					//user who created the open trade order
-	Timestamp int64 `json:"timestamp"`			//utc timestamp of creation
+	Timestamp int64 `json:"timestamp"`			//utc timestamp of creation, legacy trades use it as their id
 	Want Description  `json:"want"`				//description of desired marble
 	Willing []Description `json:"willing"`		//array of marbles willing to trade away
 }
@@ -90,8 +103,10 @@ type AllTrades struct{
 }
 
 type Adminlogin struct{
+	Record
 	Userid string `json:"userid"`					//User login for system Admin
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`	//legacy clear text, hashed by migrate_passwords
+	Passwordhash string `json:"passwordhash,omitempty"`	//salted hash, see credentials.go
 }
 
 // ============================================================================================================================
@@ -105,65 +120,15 @@ func main() {
 }
 
 // ============================================================================================================================
-// Init - reset all the things
+// Init - create whatever system keys are missing, safe to run again on upgrade, see setup.go
 // ============================================================================================================================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	var Aval int
-	var err error
-
-	
-//Changes for the Hertz Blockchain
-
-   if len(args) != 2 {
-	   return nil, errors.New("Incorrect number of arguments. Expecting 1")
-	}
-    //Write the User Id "mail Id" arg[0] and password arg[1]
-	userid := args[0]															//argument for UserID
-	password := args[1]  	//argument for password
-	str := `{"userid": "` + userid+ `", "password": "` + password + `"}`
-	
-	err = stub.PutState(userid, []byte(str))								//Put the userid and password in blockchain
-	if err != nil {
-		return nil, err
-	}
-	
-	
-//End of Changes for the Hertz Blockchain}
-
-	// Initialize the chaincode
-	Aval, err = strconv.Atoi(args[0])
+	err := checkArgs(initSchemas, "init", args)
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, toChaincodeError(function, err)
 	}
-
-	// Write the state to the ledger
-	err = stub.PutState("abc", []byte(strconv.Itoa(Aval)))				//making a test var "abc", I find it handy to read/write to it right away to test the network
-	if err != nil {
-		return nil, err
-	}
-	
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty)								//marshal an emtpy array of strings to clear the index
-	err = stub.PutState(marbleIndexStr, jsonAsBytes)
-	if err != nil {
-		return nil, err
-	}
-	
-	//var empty []string
-	 //jsonAsBytes, _ := json.Marshal(empty)								//marshal an emtpy array of strings to clear the index
-	 err = stub.PutState(driverIndexStr, jsonAsBytes)
-	 if err != nil {
-		return nil, err
-	 }
-	
-	var trades AllTrades
-	jsonAsBytes, _ = json.Marshal(trades)								//clear the open trade struct
-	err = stub.PutState(openTradesStr, jsonAsBytes)
-	if err != nil {
-		return nil, err
-	}
-	
-	return nil, nil
+	res, err := t.initLedger(stub, args)
+	return res, toChaincodeError(function, err)
 }
 
 // ============================================================================================================================
@@ -179,14 +144,27 @@ func (t *SimpleChaincode) Run(stub shim.ChaincodeStubInterface, function string,
 // ============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
+	err := checkArgs(invokeSchemas, function, args)							//check the args before running anything
+	if err != nil {
+		return nil, toChaincodeError(function, err)
+	}
+	err = authorize(stub, function, args)									//check the caller may run it
+	if err != nil {
+		return nil, toChaincodeError(function, err)
+	}
+	res, err := t.invoke(stub, function, args)
+	if err != nil {
+		return nil, toChaincodeError(function, err)
+	}
+	return res, nil
+}
 
+func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	// Handle different functions
-	if function == "init" {													//initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "delete" {										//deletes an entity from its state
-		res, err := t.Delete(stub, args)
-		cleanTrades(stub)													//lets make sure all open trades are still valid
-		return res, err
+	if function == "reset_ledger" {											//empty the ledger, init only creates what is missing
+		return t.reset_ledger(stub, args)
+	} else if function == "delete" || function == "delete_marble" {		//retire a marble, delete is the old name
+		return t.Delete(stub, args)
 	} else if function == "write" {											//writes a value to the chaincode state
 		return t.Write(stub, args)
 	} else if function == "init_marble" {									//create a new marble
@@ -196,25 +174,61 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 	} else if function == "book_car" {									//create a new marble
 		return t.book_car(stub, args)
 	}else if function == "set_user" {										//change owner of a marble
-		res, err := t.set_user(stub, args)
-		cleanTrades(stub)													//lets make sure all open trades are still valid
-		return res, err	
+		return t.set_user(stub, args)
 	}else if function == "set_status" {										//change owner of a marble
-		res, err := t.set_status(stub, args)
-		cleanTrades(stub)													//lets make sure all open trades are still valid
-		return res, err	
+		return t.set_status(stub, args)
 	} else if function == "open_trade" {									//create a new trade order
 		return t.open_trade(stub, args)
 	} else if function == "perform_trade" {									//forfill an open trade order
-		res, err := t.perform_trade(stub, args)
-		cleanTrades(stub)													//lets clean just in case
-		return res, err
+		return t.perform_trade(stub, args)
 	} else if function == "remove_trade" {									//cancel an open trade order
 		return t.remove_trade(stub, args)
+	} else if function == "repair_records" {								//rewrite records stored before json marshalling
+		return t.repair_records(stub, args)
+	} else if function == "migrate_passwords" {								//hash passwords stored in clear text
+		return t.migrate_passwords(stub, args)
+	} else if function == "migrate_keys" {									//move records off legacy flat keys
+		return t.migrate_keys(stub, args)
+	} else if function == "update_driver" {								//change some fields of a driver
+		return t.update_driver(stub, args)
+	} else if function == "review_driver" {								//start reviewing a pending driver
+		return t.review_driver(stub, args)
+	} else if function == "approve_driver" {								//approve a driver under review
+		return t.approve_driver(stub, args)
+	} else if function == "reject_driver" {								//reject a driver under review
+		return t.reject_driver(stub, args)
+	} else if function == "suspend_driver" {								//suspend an approved driver
+		return t.suspend_driver(stub, args)
+	} else if function == "reinstate_driver" {								//lift a suspension
+		return t.reinstate_driver(stub, args)
+	} else if function == "delete_driver" {								//remove a driver and their bookings
+		return t.delete_driver(stub, args)
+	} else if function == "cancel_booking" {								//call off a booking before pickup
+		return t.cancel_booking(stub, args)
+	} else if function == "confirm_booking" {								//accept a requested booking
+		return t.confirm_booking(stub, args)
+	} else if function == "pickup_booking" {								//hand the car to an approved driver
+		return t.pickup_booking(stub, args)
+	} else if function == "return_booking" {								//take the car back
+		return t.return_booking(stub, args)
+	} else if function == "no_show_booking" {								//the driver never came
+		return t.no_show_booking(stub, args)
+	} else if function == "restore_marble" {								//bring a deleted marble back
+		return t.restore_marble(stub, args)
+	} else if function == "purge_marble" {									//remove a deleted marble for good
+		return t.purge_marble(stub, args)
+	} else if function == "rebuild_indexes" {								//index every marble again
+		return t.rebuild_indexes(stub, args)
+	} else if function == "register_user" {									//create a user who can own marbles
+		return t.register_user(stub, args)
+	} else if function == "deactivate_user" {								//stop a user from receiving marbles
+		return t.deactivate_user(stub, args)
+	} else if function == "register_owners" {								//register owners of existing marbles
+		return t.register_owners(stub, args)
 	}
 	fmt.Println("invoke did not find func: " + function)					//error
 
-	return nil, errors.New("Received unknown function invocation")
+	return nil, newError(CodeUnknownFunction, "Received unknown function invocation")
 }
 
 // ============================================================================================================================
@@ -222,109 +236,167 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 // ============================================================================================================================
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("query is running " + function)
+	err := checkArgs(querySchemas, function, args)							//check the args before running anything
+	if err != nil {
+		return nil, toChaincodeError(function, err)
+	}
+	res, err := t.query(stub, function, args)
+	if err != nil {
+		return nil, toChaincodeError(function, err)
+	}
+	return res, nil
+}
 
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	// Handle different functions
 	if function == "read" {													//read a variable
 		return t.read(stub, args)
-	} else if function == "read_sysadmin" {									//Read system admin User id and password
+	} else if function == "read_marble" {									//read a marble by name
+		return t.read_marble(stub, args)
+	} else if function == "marble_history" {								//chain of custody of a marble
+		return t.marble_history(stub, args)
+	} else if function == "get_booking" {									//read one booking of a driver
+		return t.get_booking(stub, args)
+	} else if function == "list_bookings_by_driver" {						//page through a driver's bookings
+		return t.list_bookings_by_driver(stub, args)
+	} else if function == "list_bookings_by_location" {					//page through bookings at a pickup location
+		return t.list_bookings_by_location(stub, args)
+	} else if function == "list_bookings_by_date" {						//page through bookings by pickup date
+		return t.list_bookings_by_date(stub, args)
+	} else if function == "list_users" {									//page through all users
+		return t.list_users(stub, args)
+	} else if function == "list_marbles" {									//page through all marbles
+		return t.list_marbles(stub, args)
+	} else if function == "marbles_by_owner" {								//page through a user's marbles
+		return t.marbles_by_owner(stub, args)
+	} else if function == "marbles_by_color" {								//page through marbles of a color
+		return t.marbles_by_color(stub, args)
+	} else if function == "marbles_in_size_range" {						//page through marbles between two sizes
+		return t.marbles_in_size_range(stub, args)
+	} else if function == "read_sysadmin" {									//Read system admin User id
 		return t.read_sysadmin(stub, args)
+	} else if function == "verify_sysadmin" {								//check system admin User id and password
+		return t.verify_sysadmin(stub, args)
+	} else if function == "verify_driver_credentials" {						//check driver email and password
+		return t.verify_driver_credentials(stub, args)
+	} else if function == "whoami" {										//identity read from the caller's certificate
+		return t.whoami(stub, args)
 	}
 	fmt.Println("query did not find func: " + function)						//error
 
-	return nil, errors.New("Received unknown function query")
+	return nil, newError(CodeUnknownFunction, "Received unknown function query")
 }
 
 // ============================================================================================================================
 // Read - read a variable from chaincode state
 // ============================================================================================================================
 func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var name, jsonResp string
+	var name string
 	var err error
 
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+		return nil, argCountError("name of the var to query")
 	}
 
 	name = args[0]
+	if name == marbleIndexStr {
+		return marbleIndexView(stub)										//compatibility view for older clients
+	}
 	valAsbytes, err := stub.GetState(name)	//get the var from chaincode 
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, ledgerError("Failed to get state for " + name)
 	}
 
 	return valAsbytes, nil													//send it onward
 }
+// ============================================================================================================================
+// Read Marble - read a marble by name, marbles are stored under namespaced keys so read can not find them
+// ============================================================================================================================
+func (t *SimpleChaincode) read_marble(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, argCountError("name of the marble to query")
+	}
+
+	valAsbytes, err := stub.GetState(marbleKey(args[0]))
+	if err != nil {
+		return nil, ledgerError("Failed to get marble " + args[0])
+	}
+	return valAsbytes, nil													//empty if there is no such marble, like read
+}
 //=============================================================
-// Read - query function to read key/value pair (System Admin read User id and Password)
+// Read - query function to read the System Admin record, without any password or hash (use verify_sysadmin to log in)
 //===============================================================================================================================
 func (t *SimpleChaincode) read_sysadmin(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the key to query")
+		return nil, argCountError("name of the key to query")
 	}
 
 	userid := args[0]
-	PassAsbytes, err := stub.GetState(userid)
-	
+	res := Adminlogin{}
+	found, err := getRecord(stub, adminKey(userid), docAdmin, &res)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + userid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	if !found || res.Userid != userid {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "System admin " + userid + " does not exist", Arg: 0}
 	}
 	
-	res := Adminlogin{}
-	json.Unmarshal(PassAsbytes,&res)
-	
-	if res.Userid == userid{
-	   fmt.Println("Userid Password Matched: " +res.Userid + res.Password)
-	  }else {
-	   fmt.Println("Wrong ID Password: " +res.Userid + res.Password)
-	   }
-	
-	return PassAsbytes, nil
+	res.Password = ""														//never hand out credentials
+	res.Passwordhash = ""
+	return json.Marshal(res)
 }
 
 // ============================================================================================================================
-// Delete - remove a key/value pair from state
+// Delete - delete_marble, retire a marble, it keeps its record and history but leaves listings and trades, see retire.go
 // ============================================================================================================================
 func (t *SimpleChaincode) Delete(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	//   0         1
+	// "name", "reason"     reason is optional
+	if len(args) < 1 || len(args) > 2 {
+		return nil, argCountError("1 or 2")
 	}
 	
 	name := args[0]
-	err := stub.DelState(name)													//remove the key from chaincode state
-	if err != nil {
-		return nil, errors.New("Failed to delete state")
+	var reason string
+	if len(args) > 1 {
+		reason = args[1]
 	}
-
-	//get the marble index
-	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	if isProtectedKey(name) {													//_opentrades and the like are not marbles
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Key " + name + " is protected, use the function that owns it", Arg: 0}
+	}
+	res := Marble{}
+	found, err := getRecord(stub, marbleKey(name), docMarble, &res)
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, err
+	}
+	if !found {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "Marble " + name + " does not exist", Arg: 0}
+	}
+	if res.Retired != nil {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Marble " + name + " is already retired", Arg: 0}
 	}
-	var marbleIndex []string
-	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
 	
-	//remove marble from index
-	for i,val := range marbleIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for " + name)
-		if val == name{															//find the correct marble
-			fmt.Println("found marble")
-			marbleIndex = append(marbleIndex[:i], marbleIndex[i+1:]...)			//remove it
-			for x:= range marbleIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + marbleIndex[x])
-			}
-			break
-		}
+	res.Retired, err = t.newRetirement(stub, reason)							//who, when and why
+	if err != nil {
+		return nil, err
 	}
-	jsonAsBytes, _ := json.Marshal(marbleIndex)									//save new index
-	err = stub.PutState(marbleIndexStr, jsonAsBytes)
-	return nil, nil
+	err = putRecord(stub, marbleKey(name), docMarble, &res)
+	if err != nil {
+		return nil, err
+	}
+	err = t.appendHistory(stub, name, res.User, "", reasonDeleted, "")
+	if err != nil {
+		return nil, err
+	}
+	removed, err := cleanTrades(stub)											//lets make sure all open trades are still valid
+	if err != nil {
+		return nil, err
+	}
+	return nil, emitEvent(stub, eventMarbleDeleted, MarbleEvent{Marble: res, RemovedTrades: removed})
 }
 
 // ============================================================================================================================
-// Write - write variable into chaincode state
+// Write - write a scratch variable into chaincode state, operators only, never a protected key, see keys.go
 // ============================================================================================================================
 func (t *SimpleChaincode) Write(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var name, value string // Entities
@@ -332,14 +404,17 @@ func (t *SimpleChaincode) Write(stub shim.ChaincodeStubInterface, args []string)
 	fmt.Println("running write()")
 
 	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
+		return nil, argCountError("2. name of the variable and value to set")
 	}
 
 	name = args[0]															//rename for funsies
 	value = args[1]
+	if isProtectedKey(name) {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Key " + name + " is protected, use the function that owns it", Arg: 0}
+	}
 	err = stub.PutState(name, []byte(value))								//write the variable into the chaincode state
 	if err != nil {
-		return nil, err
+		return nil, ledgerError("Failed to write " + name)
 	}
 	return nil, nil
 }
@@ -353,7 +428,7 @@ func (t *SimpleChaincode) init_marble(stub shim.ChaincodeStubInterface, args []s
 	//   0       1       2     3
 	// "asdf", "blue", "35", "bob"
 	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+		return nil, argCountError("4")
 	}
 	
     fmt.Println("-Amit C code-Init_marble driver")
@@ -361,61 +436,58 @@ func (t *SimpleChaincode) init_marble(stub shim.ChaincodeStubInterface, args []s
 	//input sanitation
 	fmt.Println("- start init marble")
 	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
+		return nil, argError(0, "1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
+		return nil, argError(1, "2nd argument must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return nil, errors.New("3rd argument must be a non-empty string")
+		return nil, argError(2, "3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return nil, errors.New("4th argument must be a non-empty string")
+		return nil, argError(3, "4th argument must be a non-empty string")
 	}
 	name := args[0]
 	color := strings.ToLower(args[1])
 	user := strings.ToLower(args[3])
 	size, err := strconv.Atoi(args[2])
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, argError(2, "3rd argument must be a numeric string")
+	}
+	err = requireActiveUser(stub, user, 3)									//only registered users own marbles
+	if err != nil {
+		return nil, err
 	}
 
 	//check if marble already exists
-	marbleAsBytes, err := stub.GetState(name)
+	marbleAsBytes, err := stub.GetState(marbleKey(name))
 	if err != nil {
-		return nil, errors.New("Failed to get marble name")
+		return nil, ledgerError("Failed to get marble name")
 	}
 	res := Marble{}
 	json.Unmarshal(marbleAsBytes, &res)
 	if res.Name == name{
 		fmt.Println("This marble arleady exists: " + name)
 		fmt.Println(res);
-		return nil, errors.New("This marble arleady exists")				//all stop a marble by this name exists
+		return nil, &ChaincodeError{Code: CodeAlreadyExists, Message: "This marble arleady exists", Arg: 0}	//all stop a marble by this name exists
 	}
 	
-	//build the marble json string manually
-	str := `{"name": "` + name + `", "color": "` + color + `", "size": ` + strconv.Itoa(size) + `, "user": "` + user + `"}`
-	err = stub.PutState(name, []byte(str))									//store marble with id as key
+	marble := Marble{Name: name, Color: color, Size: size, User: user}
+	err = putRecord(stub, marbleKey(name), docMarble, &marble)				//store marble with id as key
 	if err != nil {
 		return nil, err
 	}
-		
-	//get the marble index
-	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	err = indexMarble(stub, marble)											//find it by owner and by color/size
 	if err != nil {
-		return nil, errors.New("Failed to get marble index")
+		return nil, err
+	}
+	err = t.appendHistory(stub, name, "", user, reasonCreated, "")			//start its chain of custody
+	if err != nil {
+		return nil, err
 	}
-	var marbleIndex []string
-	json.Unmarshal(marblesAsBytes, &marbleIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	marbleIndex = append(marbleIndex, name)									//add marble name to index list
-	fmt.Println("! marble index: ", marbleIndex)
-	jsonAsBytes, _ := json.Marshal(marbleIndex)
-	err = stub.PutState(marbleIndexStr, jsonAsBytes)						//store name of marble
 
 	fmt.Println("- end init marble")
-	return nil, nil
+	return nil, emitEvent(stub, eventMarbleCreated, MarbleEvent{Marble: marble})
 }
 
 
@@ -427,6 +499,9 @@ func (t *SimpleChaincode) signup_driver(stub shim.ChaincodeStubInterface, args [
 
 	//   0       1       			2						 3
 	// "Mainak", "Mandal", "mainakmandal@hotmail.com", "password"
+	if len(args) != 12 {
+		return nil, argCountError("12")
+	}
 	
 	name := args[0]
 	dl := args[1]
@@ -435,11 +510,14 @@ func (t *SimpleChaincode) signup_driver(stub shim.ChaincodeStubInterface, args [
 	mobile := args[4]
 	password := args[5]
 	address := args[6]
-	status := args[7]
 	modifyby := args[8]
 	adminemail := args[9]
 	rejectreason := args[10]
 	anycomment := args[11]
+	err = keepStatusFields(Driver{}, args, 7)								//a new driver is pending, see drivers.go
+	if err != nil {
+		return nil, err
+	}
 
 	
 	//if err != nil {
@@ -447,21 +525,20 @@ func (t *SimpleChaincode) signup_driver(stub shim.ChaincodeStubInterface, args [
 	//}
 
 	//check if marble already exists
-	driverAsBytes, err := stub.GetState(email)
+	driverAsBytes, err := stub.GetState(driverKey(email))
 	if err != nil {
-		return nil, errors.New("Failed to get driver name")
+		return nil, ledgerError("Failed to get driver name")
 	}
 	res := Driver{}
 	json.Unmarshal(driverAsBytes, &res)
 	if res.Email == email{
-		fmt.Println("This marble arleady exists: " + email)
-		fmt.Println(res);
-		return nil, errors.New("This driver arleady exists")				//all stop a marble by this name exists
+		fmt.Println("This driver already exists: " + email)				//only the email, never the stored record
+		return nil, &ChaincodeError{Code: CodeAlreadyExists, Message: "This driver arleady exists", Arg: 3}	//all stop a marble by this name exists
 	}
 	
-	//build the marble json string manually
-	str := `{"name": "` + name + `", "dl": "` + dl + `", "dob": "` + dob + `", "email": "` + email + `",  "mobile": "` + mobile + `", "password": "` + password + `","address": "` + address + `","status": "` + status + `","modifyby": "` + modifyby + `" ,"adminemail": "` + adminemail + `" ,"rejectreason": "` + rejectreason + `" ,"anycomment": "` + anycomment + `"}`
-	err = stub.PutState(email, []byte(str))									//store marble with id as key
+	driver := Driver{Name: name, DL: dl, DOB: dob, Email: email, Mobile: mobile, Passwordhash: hashPassword(stub, email, password), Address: address,
+		Status: statusPending, Modifyby: modifyby, Adminemail: adminemail, Rejectreason: rejectreason, Anycomment: anycomment}
+	err = putDriver(stub, &driver)											//store driver with email as key
 	if err != nil {
 		return nil, err
 	}
@@ -469,7 +546,7 @@ func (t *SimpleChaincode) signup_driver(stub shim.ChaincodeStubInterface, args [
 	//get the driver index
 	driversAsBytes, err := stub.GetState(driverIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get driver index")
+		return nil, ledgerError("Failed to get driver index")
 	}
 	var driverIndex []string
 	json.Unmarshal(driversAsBytes, &driverIndex)							//un stringify it aka JSON.parse()
@@ -479,9 +556,12 @@ func (t *SimpleChaincode) signup_driver(stub shim.ChaincodeStubInterface, args [
 	 fmt.Println("! driver index: ", driverIndex)
 	 jsonAsBytes, _ := json.Marshal(driverIndex)
 	 err = stub.PutState(driverIndexStr, jsonAsBytes)						//store name of marble
+	 if err != nil {
+		return nil, ledgerError("Failed to write driver index")
+	 }
 
 	fmt.Println("- end signup driver")
-	return nil, nil
+	return nil, emitEvent(stub, eventDriverSignedUp, driverEvent(driver))
 }
 // ============================================================================================================================
 // Book_car - create a new booking, store into chaincode state
@@ -491,95 +571,80 @@ func (t *SimpleChaincode) book_car(stub shim.ChaincodeStubInterface, args []stri
 	
 	//   0       1       			2						 3
 	// "Mainak", "Mandal", "mainakmandal@hotmail.com", "password"
+	if len(args) != 10 {
+		return nil, argCountError("10")
+	}
 	
 	bookacarname := args[0]
 	bookacaremail := args[1]
 	bookacarclass := args[2]
-	bookacarlocation := args[3]
-        bookacardroplocation := args[4]
-	bookacarpickupdate := args[5]
-	bookacarpickuptime := args[6]
-	bookacardropoffdate := args[7]
-	bookacardropofftime := args[8]
+	bookacarlocation := strings.TrimSpace(args[3])								//required by the schema
+	bookacardroplocation := strings.TrimSpace(args[4])
+	bookacarpickupdate := dateKey(args[5])										//stored as 2016-12-31 and 17:30
+	bookacarpickuptime := clockKey(args[6])
+	bookacardropoffdate := dateKey(args[7])
+	bookacardropofftime := clockKey(args[8])
 	bookingid := args[9]	
-	
-	//This function is to check the login id of Driver
-	  PassAsbytes, err := stub.GetState(bookacaremail)
-	
-	  fmt.Println("Wrong ID Password-1: " +bookacaremail)
-	
-	  if err != nil {
-		 jsonResp := "{\"Error\":\"Failed to get state for " + bookacaremail + "\"}"
-		 return nil, errors.New(jsonResp)
-	     }
-	
-	  res := Driver{}
-	  json.Unmarshal(PassAsbytes,&res)
-	  fmt.Println("res.Email-2: " +res.Email)
-	
-	  if res.Email != bookacaremail{
-	    fmt.Println("Wrong ID Password-3: " +res.Email)
-	    
-	  }else {
-	    fmt.Println("Userid Password Matched-4: " +res.Email)
-	  
-	//-----------------------------------------------------------------
-	//build the Bookid json string manually
-	str := `{"bookacarname": "` + bookacarname + `", "bookacaremail": "` + bookacaremail + `", "bookacarclass": "` + bookacarclass + `","bookacarlocation": "` + bookacarlocation + `", "bookacardroplocation": "` + bookacardroplocation + `","bookacarpickupdate": "` + bookacarpickupdate + `", "bookacarpickuptime": "` + bookacarpickuptime + `", "bookacardropoffdate": "` + bookacardropoffdate + `", "bookacardropofftime": "` + bookacardropofftime + `","bookingid": "` + bookingid + `"}`
-	err = stub.PutState(bookacaremail+bookingid, []byte(str))									 
+	now, err := t.now(stub)
 	if err != nil {
 		return nil, err
 	}
+	timestamp := toMillis(now)
 	
-	//-------------------------------------------------get the driver index
-	driversAsBytes, err := stub.GetState(driverIndexStr)
+	//the car must be picked up from now on and dropped off after that
+	pickup, err := bookingInstant(bookacarpickupdate, bookacarpickuptime)		//both checked by the schema
 	if err != nil {
-		return nil, errors.New("Failed to get driver index")
+		return nil, argError(5, "Expecting a pickup date and time")
 	}
-	var driverIndex []string
-	json.Unmarshal(driversAsBytes, &driverIndex)							 
-	
-	//append
-	 driverIndex = append(driverIndex, bookingid)									 
-	 fmt.Println("! driver index: ", driverIndex)
-	 jsonAsBytes1, _ := json.Marshal(driverIndex)
-	 err = stub.PutState(driverIndexStr, jsonAsBytes1)	
-     //-------------------------------------------------Driver index end	 
-
-    
-	//++++++++Boooking id for driver details
-	driverAsBytes, err := stub.GetState(args[1])
+	dropoff, err := bookingInstant(bookacardropoffdate, bookacardropofftime)
 	if err != nil {
-		return nil, errors.New("Failed to get driver name")
+		return nil, argError(7, "Expecting a dropoff date and time")
+	}
+	if pickup.Before(now) {
+		return nil, argError(5, "The pickup "+bookacarpickupdate+" "+bookacarpickuptime+" UTC is in the past")
+	}
+	if !dropoff.After(pickup) {
+		return nil, argError(7, "The dropoff must be after the pickup")
 	}
-	res := Driver{}
-	json.Unmarshal(driverAsBytes, &res)
-	res.Bookingid = args[9]	 //change the user
- 	jsonAsBytes, _ := json.Marshal(res)
- 	err = stub.PutState(args[1], jsonAsBytes)
 	
+	//the driver must exist and not have used this booking id yet
+	res, err := getDriver(stub, bookacaremail, 1)
+	if err != nil {
+		return nil, err
+	}
+	bookacaremail = res.Email
+	found, err := getRecord(stub, bookingKey(bookacaremail, bookingid), docBooking, &Bookcar{})
 	if err != nil {
 		return nil, err
 	}
+	if found {
+		return nil, &ChaincodeError{Code: CodeAlreadyExists, Message: "Booking " + bookingid + " of " + bookacaremail + " already exists", Arg: 9}
+	}
 	
-	//---------------------------get the driver index for Driver details
-	driversAsBytes1, err := stub.GetState(driverIndexStr)
+	booking := Bookcar{Bookacarname: bookacarname, Bookacaremail: bookacaremail, Bookacarclass: bookacarclass,
+		Bookacarlocation: bookacarlocation, Bookacardroplocation: bookacardroplocation,
+		Bookacarpickupdate: bookacarpickupdate, Bookacarpickuptime: bookacarpickuptime,
+		Bookacardropoffdate: bookacardropoffdate, Bookacardropofftime: bookacardropofftime,
+		Bookingid: bookingid, Timestamp: timestamp, Pickupat: toMillis(pickup), Dropoffat: toMillis(dropoff), Status: bookingRequested,
+		Statushistory: []StatusChange{{To: bookingRequested, By: getCaller(stub).Id, Timestamp: timestamp}}}
+	err = putRecord(stub, bookingKey(bookacaremail, bookingid), docBooking, &booking)
 	if err != nil {
-		return nil, errors.New("Failed to get driver index")
+		return nil, err
+	}
+	err = indexBooking(stub, booking)											//find it by location and date, see bookings.go
+	if err != nil {
+		return nil, err
 	}
-	var driverIndex1 []string
-	json.Unmarshal(driversAsBytes1, &driverIndex1)							 
 	
-	//append
-	 driverIndex1 = append(driverIndex1, bookacaremail)									 
-	 fmt.Println("! driver index: ", driverIndex1)
-	 jsonAsBytes3, _ := json.Marshal(driverIndex1)
-	 err = stub.PutState(driverIndexStr, jsonAsBytes3)		
-	 //----------------------------------------------------------------------
-	 
-	fmt.Println("- end signup driver")
+	res.Bookings = append(res.Bookings, bookingid)								//the driver keeps every booking
+	res.Bookingid = bookingid													//and the latest one for older clients
+ 	err = putDriver(stub, &res)
+	if err != nil {
+		return nil, err
 	}
-	return PassAsbytes, nil
+	 
+	fmt.Println("- end book car")
+	return nil, emitEvent(stub, eventCarBooked, BookingEvent{Booking: booking})
 }
 // ============================================================================================================================
 // Set User Permission on Marble
@@ -589,69 +654,96 @@ func (t *SimpleChaincode) book_car(stub shim.ChaincodeStubInterface, args []stri
 	//   0       1
 	// "name", "bob"
 	if len(args) < 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+		return nil, argCountError("2")
 	}
 	
 	 fmt.Println("- start set user")
 	 fmt.Println(args[0] + " - " + args[1])
-	 marbleAsBytes, err := stub.GetState(args[0])
- 	if err != nil {
-	 	return nil, errors.New("Failed to get thing")
+	 err = requireActiveUser(stub, args[1], 1)								//only registered users own marbles
+	 if err != nil {
+		return nil, err
 	 }
-	 res := Marble{}
-	 json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
-	 res.User = args[1]														//change the user
-	
- 	jsonAsBytes, _ := json.Marshal(res)
- 	err = stub.PutState(args[0], jsonAsBytes)								//rewrite the marble with id as key
-	if err != nil {
+	 previous, err := t.setOwner(stub, args[0], args[1], reasonTransferred, "")
+	 if err != nil {
 		return nil, err
-	}
+	 }
+	 removed, err := cleanTrades(stub)											//lets make sure all open trades are still valid
+	 if err != nil {
+		return nil, err
+	 }
 	
  	fmt.Println("- end set user")
-	return nil, nil
+	return nil, emitEvent(stub, eventOwnerChanged, OwnerEvent{Marble: args[0], From: previous, To: strings.ToLower(args[1]), RemovedTrades: removed})
   }
+
+// ============================================================================================================================
+// setOwner - give marble name to user, keeping its indexes and ownership history in step
+// ============================================================================================================================
+func (t *SimpleChaincode) setOwner(stub shim.ChaincodeStubInterface, name string, user string, reason string, tradeId string) (previous string, err error) {
+	user = strings.ToLower(user)													//owners are stored lower case, see users.go
+	res := Marble{}
+	found, err := getRecord(stub, marbleKey(name), docMarble, &res)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", &ChaincodeError{Code: CodeNotFound, Message: "Marble " + name + " does not exist", Arg: 0}
+	}
+	if res.Retired != nil {
+		return "", &ChaincodeError{Code: CodeNotAllowed, Message: "Marble " + name + " is retired, restore it first", Arg: 0}
+	}
+	err = unindexMarble(stub, res)												//drop the old owner's index entry
+	if err != nil {
+		return "", err
+	}
+	previous = res.User
+	res.User = user																//change the user
+	
+	err = putRecord(stub, marbleKey(name), docMarble, &res)						//rewrite the marble with id as key
+	if err != nil {
+		return "", err
+	}
+	err = indexMarble(stub, res)
+	if err != nil {
+		return "", err
+	}
+	return previous, t.appendHistory(stub, name, previous, user, reason, tradeId)
+}
   
  // ============================================================================================================================
-// Set User Permission on Marble
+// Set Status - rewrite a driver's whole profile, kept for older clients, update_driver changes single fields
 // ============================================================================================================================
   func (t *SimpleChaincode) set_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
   	var err error
 	//   0       1
 	// "email", "status"
-	if len(args) < 8 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	if len(args) != 12 {
+		return nil, argCountError("12")
 	}
 	
 	 fmt.Println("- start set user")
 	 fmt.Println(args[0] + " - " + args[1])
 	 
-	driverAsBytes, err := stub.GetState(args[0])
+	res, err := getDriver(stub, args[0], 0)
 	if err != nil {
-		return nil, errors.New("Failed to get driver name")
+		return nil, err
+	}
+	err = keepStatusFields(res, args, 7)									//only the transitions in drivers.go change these
+	if err != nil {
+		return nil, err
+	}
+	err = setProfile(stub, &res, args)										//only the fields update_driver would let the caller change
+	if err != nil {
+		return nil, err
 	}
-	res := Driver{}
-	json.Unmarshal(driverAsBytes, &res) 
-	 res.Name = args[1]	 //change the user
-	 res.DL = args[2]
-	 res.DOB = args[3]
-	 res.Mobile = args[4]
-	 res.Password = args[5]
-	 res.Address = args[6]
-	 res.Status = args[7]
-	 res.Modifyby = args[8]
-	 res.Adminemail  = args[9]
-	 res.Rejectreason   = args[10]
-	 res.Anycomment   = args[11]
 	 
- 	jsonAsBytes, _ := json.Marshal(res)
- 	err = stub.PutState(args[0], jsonAsBytes)								//rewrite the user status with email-id as key
+ 	err = putDriver(stub, &res)												//rewrite the user status with email-id as key
 	if err != nil {
 		return nil, err
 	}
 	
  	fmt.Println("- end set user")
-	return nil, nil
+	return nil, emitEvent(stub, eventDriverUpdated, driverEvent(res))
   } 
 // ============================================================================================================================
 // Open Trade - create an open trade for a marble you want with marbles you have 
@@ -664,40 +756,43 @@ func (t *SimpleChaincode) open_trade(stub shim.ChaincodeStubInterface, args []st
 	//	0        1      2     3      4      5       6
 	//["bob", "blue", "16", "red", "16"] *"blue", "35*
 	if len(args) < 5 {
-		return nil, errors.New("Incorrect number of arguments. Expecting like 5?")
+		return nil, argCountError("at least 5")
 	}
 	if len(args)%2 == 0{
-		return nil, errors.New("Incorrect number of arguments. Expecting an odd number")
+		return nil, argCountError("an odd number")
 	}
 
 	size1, err := strconv.Atoi(args[2])
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, argError(2, "3rd argument must be a numeric string")
 	}
 
 	open := AnOpenTrade{}
-	open.User = args[0]
-	open.Timestamp = makeTimestamp()											//use timestamp as an ID
-	open.Want.Color = args[1]
+	open.User = strings.ToLower(args[0])											//stored lower case like owners and colors
+	open.Timestamp, err = t.makeTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	open.Want.Color = strings.ToLower(args[1])
 	open.Want.Size =  size1
 	fmt.Println("- start open trade")
-	jsonAsBytes, _ := json.Marshal(open)
-	err = stub.PutState("_debug1", jsonAsBytes)
+	err = requireActiveUser(stub, open.User, 0)
+	if err != nil {
+		return nil, err
+	}
 
 	for i:=3; i < len(args); i++ {												//create and append each willing trade
 		will_size, err = strconv.Atoi(args[i + 1])
 		if err != nil {
 			msg := "is not a numeric string " + args[i + 1]
 			fmt.Println(msg)
-			return nil, errors.New(msg)
+			return nil, argError(i + 1, msg)
 		}
 		
 		trade_away = Description{}
-		trade_away.Color = args[i]
+		trade_away.Color = strings.ToLower(args[i])
 		trade_away.Size =  will_size
 		fmt.Println("! created trade_away: " + args[i])
-		jsonAsBytes, _ = json.Marshal(trade_away)
-		err = stub.PutState("_debug2", jsonAsBytes)
 		
 		open.Willing = append(open.Willing, trade_away)
 		fmt.Println("! appended willing to open")
@@ -707,20 +802,21 @@ func (t *SimpleChaincode) open_trade(stub shim.ChaincodeStubInterface, args []st
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, ledgerError("Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)										//un stringify it aka JSON.parse()
 	
+	open.Id = makeTradeId(stub, trades)											//id is unique per transaction, same on every peer
 	trades.OpenTrades = append(trades.OpenTrades, open);						//append to open trades
 	fmt.Println("! appended open to trades")
-	jsonAsBytes, _ = json.Marshal(trades)
+	jsonAsBytes, _ := json.Marshal(trades)
 	err = stub.PutState(openTradesStr, jsonAsBytes)								//rewrite open orders
 	if err != nil {
-		return nil, err
+		return nil, ledgerError("Failed to write open trades")
 	}
 	fmt.Println("- end open trade")
-	return nil, nil
+	return nil, emitEvent(stub, eventTradeOpened, TradeEvent{Trade: open})
 }
 
 // ============================================================================================================================
@@ -732,66 +828,119 @@ func (t *SimpleChaincode) perform_trade(stub shim.ChaincodeStubInterface, args [
 	//	0		1					2					3				4					5
 	//[data.id, data.closer.user, data.closer.name, data.opener.user, data.opener.color, data.opener.size]
 	if len(args) < 6 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 6")
+		return nil, argCountError("6")
 	}
 	
 	fmt.Println("- start close trade")
-	timestamp, err := strconv.ParseInt(args[0], 10, 64)
-	if err != nil {
-		return nil, errors.New("1st argument must be a numeric string")
-	}
-	
 	size, err := strconv.Atoi(args[5])
 	if err != nil {
-		return nil, errors.New("6th argument must be a numeric string")
+		return nil, argError(5, "6th argument must be a numeric string")
 	}
 	
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, ledgerError("Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)															//un stringify it aka JSON.parse()
 	
-	for i := range trades.OpenTrades{																//look for the trade
-		fmt.Println("looking at " + strconv.FormatInt(trades.OpenTrades[i].Timestamp, 10) + " for " + strconv.FormatInt(timestamp, 10))
-		if trades.OpenTrades[i].Timestamp == timestamp{
-			fmt.Println("found the trade");
-			
-			
-			marbleAsBytes, err := stub.GetState(args[2])
-			if err != nil {
-				return nil, errors.New("Failed to get thing")
-			}
-			closersMarble := Marble{}
-			json.Unmarshal(marbleAsBytes, &closersMarble)											//un stringify it aka JSON.parse()
-			
-			//verify if marble meets trade requirements
-			if closersMarble.Color != trades.OpenTrades[i].Want.Color || closersMarble.Size != trades.OpenTrades[i].Want.Size {
-				msg := "marble in input does not meet trade requriements"
-				fmt.Println(msg)
-				return nil, errors.New(msg)
-			}
-			
-			marble, e := findMarble4Trade(stub, trades.OpenTrades[i].User, args[4], size)			//find a marble that is suitable from opener
-			if(e == nil){
-				fmt.Println("! no errors, proceeding")
+	pos, err := findTrade(trades, args[0])															//look for the trade
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("found the trade");
+	trade := trades.OpenTrades[pos]
+	
+	//validate everything before writing anything
+	if strings.ToLower(trade.User) != strings.ToLower(args[3]) {
+		return nil, argError(3, "Trade " + args[0] + " was opened by " + trade.User + ", not " + args[3])
+	}
+	if strings.ToLower(trade.User) == strings.ToLower(args[1]) {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "User " + args[1] + " can not close their own trade", Arg: 1}
+	}
+	err = requireActiveUser(stub, args[1], 1)
+	if err != nil {
+		return nil, err
+	}
+	err = requireActiveUser(stub, trade.User, 3)
+	if err != nil {
+		return nil, err
+	}
+	
+	marbleAsBytes, err := stub.GetState(marbleKey(args[2]))
+	if err != nil {
+		return nil, ledgerError("Failed to get marble " + args[2])
+	}
+	if marbleAsBytes == nil {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "Marble " + args[2] + " does not exist", Arg: 2}
+	}
+	closersMarble := Marble{}
+	err = json.Unmarshal(marbleAsBytes, &closersMarble)											//un stringify it aka JSON.parse()
+	if err != nil {
+		return nil, &ChaincodeError{Code: CodeCorruptRecord, Message: "Marble " + args[2] + " is corrupt", Arg: 2}
+	}
+	if closersMarble.Retired != nil {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Marble " + args[2] + " is retired", Arg: 2}
+	}
+	if strings.ToLower(closersMarble.User) != strings.ToLower(args[1]) {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "Marble " + args[2] + " is not owned by " + args[1], Arg: 2}
+	}
+	
+	//verify if marble meets trade requirements
+	if strings.ToLower(closersMarble.Color) != strings.ToLower(trade.Want.Color) || closersMarble.Size != trade.Want.Size {	//trades opened before colors were stored lower case
+		msg := "marble in input does not meet trade requriements"
+		fmt.Println(msg)
+		return nil, &ChaincodeError{Code: CodeTradeMismatch, Message: msg, Arg: 2}
+	}
+	
+	if !tradeWilling(trade, args[4], size) {
+		return nil, &ChaincodeError{Code: CodeTradeMismatch, Message: "Trade " + args[0] + " is not willing to give a " + args[4] + " marble of size " + args[5], Arg: 4}
+	}
+	
+	marble, err := findMarble4Trade(stub, trade.User, args[4], size)							//find a marble that is suitable from opener
+	if hasCode(err, CodeNotFound) {
+		return nil, &ChaincodeError{Code: CodeTradeMismatch, Message: "Opener " + trade.User + " no longer owns a " + args[4] + " marble of size " + args[5], Arg: 4}
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	//all checks passed, swap owners and remove the trade
+	fmt.Println("! no errors, proceeding")
+	_, err = t.setOwner(stub, closersMarble.Name, trade.User, reasonTraded, tradeLabel(trade))	//change owner of selected marble, closer -> opener
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.setOwner(stub, marble.Name, args[1], reasonTraded, tradeLabel(trade))			//change owner of selected marble, opener -> closer
+	if err != nil {
+		return nil, err
+	}
+	
+	trades.OpenTrades = append(trades.OpenTrades[:pos], trades.OpenTrades[pos+1:]...)			//remove trade
+	jsonAsBytes, _ := json.Marshal(trades)
+	err = stub.PutState(openTradesStr, jsonAsBytes)												//rewrite open orders
+	if err != nil {
+		return nil, ledgerError("Failed to write open trades")
+	}
+	removed, err := cleanTrades(stub)															//lets clean just in case
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end close trade")
+	return nil, emitEvent(stub, eventTradeCompleted, TradeCompletedEvent{Trade: trade, Closer: strings.ToLower(args[1]), OpenerMarble: marble.Name, CloserMarble: closersMarble.Name, RemovedTrades: removed})
+}
 
-				t.set_user(stub, []string{args[2], trades.OpenTrades[i].User})						//change owner of selected marble, closer -> opener
-				t.set_user(stub, []string{marble.Name, args[1]})									//change owner of selected marble, opener -> closer
-			
-				trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)		//remove trade
-				jsonAsBytes, _ := json.Marshal(trades)
-				err = stub.PutState(openTradesStr, jsonAsBytes)										//rewrite open orders
-				if err != nil {
-					return nil, err
-				}
-			}
+// ============================================================================================================================
+// tradeWilling - check if an open trade offers a marble of this color and size
+// ============================================================================================================================
+func tradeWilling(trade AnOpenTrade, color string, size int) bool {
+	for _, w := range trade.Willing {
+		if strings.ToLower(w.Color) == strings.ToLower(color) && w.Size == size {
+			return true
 		}
 	}
-	fmt.Println("- end close trade")
-	return nil, nil
+	return false
 }
 
 // ============================================================================================================================
@@ -802,42 +951,85 @@ func findMarble4Trade(stub shim.ChaincodeStubInterface, user string, color strin
 	fmt.Println("- start find marble 4 trade")
 	fmt.Println("looking for " + user + ", " + color + ", " + strconv.Itoa(size));
 
-	//get the marble index
-	marblesAsBytes, err := stub.GetState(marbleIndexStr)
+	var found *Marble
+	err = scanIndex(stub, nsMarbleByColor, []string{strings.ToLower(color), sizeKey(size)}, func(name string) (bool, error) {	//only marbles of this color and size
+		res := Marble{}
+		ok, err := getRecord(stub, marbleKey(name), docMarble, &res)
+		if err != nil || !ok || res.Retired != nil {
+			return err == nil, err												//stale entries and retired marbles do not trade
+		}
+		//check for user
+		if strings.ToLower(res.User) == strings.ToLower(user) {
+			found = &res
+			return false, nil
+		}
+		return true, nil
+	})
 	if err != nil {
-		return fail, errors.New("Failed to get marble index")
+		return fail, err
+	}
+	if found != nil {
+		fmt.Println("found a marble: " + found.Name)
+		fmt.Println("! end find marble 4 trade")
+		return *found, nil
 	}
-	var marbleIndex []string
-	json.Unmarshal(marblesAsBytes, &marbleIndex)								//un stringify it aka JSON.parse()
 	
-	for i:= range marbleIndex{													//iter through all the marbles
-		//fmt.Println("looking @ marble name: " + marbleIndex[i]);
+	fmt.Println("- end find marble 4 trade - error")
+	return fail, newError(CodeNotFound, "Did not find marble to use in this trade")
+}
 
-		marbleAsBytes, err := stub.GetState(marbleIndex[i])						//grab this marble
-		if err != nil {
-			return fail, errors.New("Failed to get marble")
-		}
-		res := Marble{}
-		json.Unmarshal(marbleAsBytes, &res)										//un stringify it aka JSON.parse()
-		//fmt.Println("looking @ " + res.User + ", " + res.Color + ", " + strconv.Itoa(res.Size));
-		
-		//check for user && color && size
-		if strings.ToLower(res.User) == strings.ToLower(user) && strings.ToLower(res.Color) == strings.ToLower(color) && res.Size == size{
-			fmt.Println("found a marble: " + res.Name)
-			fmt.Println("! end find marble 4 trade")
-			return res, nil
+// ============================================================================================================================
+// Make Trade Id - build a trade id from the transaction id and the number of trades this transaction already opened
+// ============================================================================================================================
+func makeTradeId(stub shim.ChaincodeStubInterface, trades AllTrades) string {
+	prefix := stub.GetTxID() + "-"
+	seq := 0
+	for i := range trades.OpenTrades{
+		if strings.HasPrefix(trades.OpenTrades[i].Id, prefix) {
+			seq++
 		}
 	}
-	
-	fmt.Println("- end find marble 4 trade - error")
-	return fail, errors.New("Did not find marble to use in this trade")
+	return prefix + strconv.Itoa(seq)
 }
 
 // ============================================================================================================================
-// Make Timestamp - create a timestamp in ms
+// Find Trade - return the position of an open trade by id, a numeric id is also matched against legacy timestamp ids
 // ============================================================================================================================
-func makeTimestamp() int64 {
-    return time.Now().UnixNano() / (int64(time.Millisecond)/int64(time.Nanosecond))
+func findTrade(trades AllTrades, id string) (int, error) {
+	if len(id) == 0 {
+		return -1, argError(0, "Trade id must be a non-empty string")
+	}
+	for i := range trades.OpenTrades{
+		if trades.OpenTrades[i].Id == id {
+			return i, nil
+		}
+	}
+	
+	timestamp, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return -1, &ChaincodeError{Code: CodeNotFound, Message: "Open trade " + id + " does not exist", Arg: 0}
+	}
+	pos := -1
+	for i := range trades.OpenTrades{
+		if trades.OpenTrades[i].Timestamp == timestamp {
+			if pos >= 0 {
+				return -1, argError(0, "Timestamp " + id + " matches more than one open trade, use the trade id")
+			}
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return -1, &ChaincodeError{Code: CodeNotFound, Message: "Open trade " + id + " does not exist", Arg: 0}
+	}
+	return pos, nil
+}
+
+// tradeLabel - the id to print for a trade, legacy trades only have a timestamp
+func tradeLabel(trade AnOpenTrade) string {
+	if len(trade.Id) > 0 {
+		return trade.Id
+	}
+	return strconv.FormatInt(trade.Timestamp, 10)
 }
 
 // ============================================================================================================================
@@ -849,64 +1041,62 @@ func (t *SimpleChaincode) remove_trade(stub shim.ChaincodeStubInterface, args []
 	//	0
 	//[data.id]
 	if len(args) < 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, argCountError("1")
 	}
 	
 	fmt.Println("- start remove trade")
-	timestamp, err := strconv.ParseInt(args[0], 10, 64)
-	if err != nil {
-		return nil, errors.New("1st argument must be a numeric string")
-	}
-	
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return nil, errors.New("Failed to get opentrades")
+		return nil, ledgerError("Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)																//un stringify it aka JSON.parse()
 	
-	for i := range trades.OpenTrades{																	//look for the trade
-		//fmt.Println("looking at " + strconv.FormatInt(trades.OpenTrades[i].Timestamp, 10) + " for " + strconv.FormatInt(timestamp, 10))
-		if trades.OpenTrades[i].Timestamp == timestamp{
-			fmt.Println("found the trade");
-			trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)				//remove this trade
-			jsonAsBytes, _ := json.Marshal(trades)
-			err = stub.PutState(openTradesStr, jsonAsBytes)												//rewrite open orders
-			if err != nil {
-				return nil, err
-			}
-			break
-		}
+	i, err := findTrade(trades, args[0])																//look for the trade
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("found the trade");
+	removed := trades.OpenTrades[i]
+	trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)						//remove this trade
+	jsonAsBytes, _ := json.Marshal(trades)
+	err = stub.PutState(openTradesStr, jsonAsBytes)														//rewrite open orders
+	if err != nil {
+		return nil, ledgerError("Failed to write open trades")
 	}
 	
 	fmt.Println("- end remove trade")
-	return nil, nil
+	return nil, emitEvent(stub, eventTradeRemoved, TradeEvent{Trade: removed})
 }
 
 // ============================================================================================================================
 // Clean Up Open Trades - make sure open trades are still possible, remove choices that are no longer possible, remove trades that have no valid choices
+//                        returns the ids of the removed trades for the event of the transaction that called it
 // ============================================================================================================================
-func cleanTrades(stub shim.ChaincodeStubInterface)(err error){
+func cleanTrades(stub shim.ChaincodeStubInterface)(removed []string, err error){
 	var didWork = false
 	fmt.Println("- start clean trades")
 	
 	//get the open trade struct
 	tradesAsBytes, err := stub.GetState(openTradesStr)
 	if err != nil {
-		return errors.New("Failed to get opentrades")
+		return nil, ledgerError("Failed to get opentrades")
 	}
 	var trades AllTrades
 	json.Unmarshal(tradesAsBytes, &trades)																		//un stringify it aka JSON.parse()
 	
 	fmt.Println("# trades " + strconv.Itoa(len(trades.OpenTrades)))
 	for i:=0; i<len(trades.OpenTrades); {																		//iter over all the known open trades
-		fmt.Println(strconv.Itoa(i) + ": looking at trade " + strconv.FormatInt(trades.OpenTrades[i].Timestamp, 10))
+		fmt.Println(strconv.Itoa(i) + ": looking at trade " + tradeLabel(trades.OpenTrades[i]))
 		
 		fmt.Println("# options " + strconv.Itoa(len(trades.OpenTrades[i].Willing)))
 		for x:=0; x<len(trades.OpenTrades[i].Willing); {														//find a marble that is suitable
 			fmt.Println("! on next option " + strconv.Itoa(i) + ":" + strconv.Itoa(x))
 			_, e := findMarble4Trade(stub, trades.OpenTrades[i].User, trades.OpenTrades[i].Willing[x].Color, trades.OpenTrades[i].Willing[x].Size)
+			if e != nil && !hasCode(e, CodeNotFound) {
+				return nil, e																				//could not look, keep the option
+			}
 			if(e != nil){
 				fmt.Println("! errors with this option, removing option")
 				didWork = true
@@ -926,6 +1116,7 @@ func cleanTrades(stub shim.ChaincodeStubInterface)(err error){
 		if len(trades.OpenTrades[i].Willing) == 0 {
 			fmt.Println("! no more options for this trade, removing trade")
 			didWork = true
+			removed = append(removed, trades.OpenTrades[i].Id)
 			trades.OpenTrades = append(trades.OpenTrades[:i], trades.OpenTrades[i+1:]...)					//remove this trade
 			i--;
 		}
@@ -942,12 +1133,12 @@ func cleanTrades(stub shim.ChaincodeStubInterface)(err error){
 		jsonAsBytes, _ := json.Marshal(trades)
 		err = stub.PutState(openTradesStr, jsonAsBytes)														//rewrite open orders
 		if err != nil {
-			return err
+			return nil, ledgerError("Failed to write open trades")
 		}
 	}else{
 		fmt.Println("! all open trades are fine")
 	}
 
 	fmt.Println("- end clean trades")
-	return nil
+	return removed, nil
 }
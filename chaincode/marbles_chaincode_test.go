@@ -0,0 +1,341 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// asOperator makes the caller of the following transactions the chaincode operator
+func asOperator(s *ledgertest.MemStub) {
+	s.SetCaller("op", roleOperator, roleRentalAdmin)
+}
+
+// as - a step Setup that changes the caller
+func as(username string, roles ...string) func(s *ledgertest.MemStub) {
+	return func(s *ledgertest.MemStub) { s.SetCaller(username, roles...) }
+}
+
+// marbleState - the stored JSON of a live marble, for Scenario.State
+func marbleState(name string, color string, size int, user string) string {
+	return fmt.Sprintf(`{"docType":"marble","version":%d,"name":%q,"color":%q,"size":%d,"user":%q}`, recordVersion, name, color, size, user)
+}
+
+// registered - the steps that register marble users
+func registered(usernames ...string) []ledgertest.Step {
+	var steps []ledgertest.Step
+	for _, username := range usernames {
+		steps = append(steps, ledgertest.Step{Function: "register_user", Args: []string{username}})
+	}
+	return steps
+}
+
+// decode - a Step.Check that unmarshals the stored record under key and hands it to check
+func decode(key string, v interface{}, check func() error) func(s *ledgertest.MemStub, res []byte) error {
+	return func(s *ledgertest.MemStub, res []byte) error {
+		if err := json.Unmarshal(s.Get(key), v); err != nil {
+			return fmt.Errorf("%s: %s", printableKey(key), err)
+		}
+		return check()
+	}
+}
+
+func TestTradeScenario(t *testing.T) {
+	sc := ledgertest.Scenario{
+		Name:     "trade",
+		Setup:    asOperator,
+		InitArgs: []string{"admin@example.com", "pw"},
+		Steps: append(registered("bob", "leroy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "red", "35", "leroy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"bob", "red", "35", "blue", "16"}, Setup: as("bob")},
+			ledgertest.Step{Function: "perform_trade", Args: []string{"tx000006-0", "leroy", "m2", "bob", "blue", "16"}, Setup: as("leroy")}, //trade ids come from the opening transaction
+			ledgertest.Step{Function: "read", Args: []string{openTradesStr}, Query: true, Want: `{"open_trades":[]}`},
+		),
+		State: map[string]string{
+			marbleKey("m1"): marbleState("m1", "blue", 16, "leroy"),
+			marbleKey("m2"): marbleState("m2", "red", 35, "bob"),
+			"_debug1":       "", //open_trade used to leave scratch keys behind
+			"_debug2":       "",
+		},
+	}
+	s, err := sc.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := s.Events[len(s.Events)-1]; last.Name != eventTradeCompleted {
+		t.Errorf("last event is %s, want %s", last.Name, eventTradeCompleted)
+	}
+}
+
+func TestDriverScenario(t *testing.T) {
+	driver := Driver{}
+	booking := Bookcar{}
+	sc := ledgertest.Scenario{
+		Name:     "driver",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: []ledgertest.Step{
+			{Function: "signup_driver", Args: []string{"Bob", "D123", "1990-05-01", "Bob@Example.com", "555-0100", "pw", "1 Main St", "", "", "", "", ""}, Setup: as("bob@example.com")},
+			{Function: "book_car", Args: []string{"Bob", "bob@example.com", "suv", "Airport", "Downtown", "2016-02-01", "09:00", "2016-02-03", "17:00", "b1"}},
+			{Function: "set_status", Args: []string{"bob@example.com", "Bob", "D123", "1990-05-01", "555-0199", "pw2", "2 Main St", statusPending, "", "", "", "moved"},
+				Check: decode(driverKey("bob@example.com"), &driver, func() error {
+					if driver.Mobile != "555-0199" || driver.Address != "2 Main St" || driver.Anycomment != "moved" || driver.Status != statusPending {
+						return fmt.Errorf("driver not updated: %+v", driver)
+					}
+					if driver.Password != "" || !driver.matches("pw2") {
+						return fmt.Errorf("password not rehashed: %+v", driver)
+					}
+					if driver.Bookingid != "b1" || len(driver.Bookings) != 1 {
+						return fmt.Errorf("booking not listed: %+v", driver)
+					}
+					return nil
+				})},
+			{Function: "get_booking", Args: []string{"bob@example.com", "b1"}, Query: true,
+				Check: decode(bookingKey("bob@example.com", "b1"), &booking, func() error {
+					if booking.Status != bookingRequested || booking.Bookacarlocation != "Airport" || booking.Bookacarpickupdate != "2016-02-01" {
+						return fmt.Errorf("booking: %+v", booking)
+					}
+					return nil
+				})},
+		},
+		State: map[string]string{
+			makeKey(nsBookingByLocation, "airport", "2016-02-01", "bob@example.com", "b1"): string(indexValue),
+			makeKey(nsBookingByDate, "2016-02-01", "bob@example.com", "b1"):                string(indexValue),
+		},
+	}
+	if _, err := sc.Run(new(SimpleChaincode)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestScenarioExample runs the example in the Scenario doc comment, keep the two in step
+func TestScenarioExample(t *testing.T) {
+	sc := ledgertest.Scenario{
+		Name:     "trade",
+		Setup:    func(s *ledgertest.MemStub) { s.SetCaller("admin", "operator") },
+		InitArgs: []string{"admin@example.com", "pw"},
+		Steps: []ledgertest.Step{
+			{Function: "register_user", Args: []string{"bob"}},
+			{Function: "register_user", Args: []string{"leroy"}},
+			{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			{Function: "init_marble", Args: []string{"m2", "red", "35", "leroy"}},
+			{Function: "open_trade", Args: []string{"bob", "red", "35", "blue", "16"}},
+		},
+		State: map[string]string{"\x00marble\x00m1\x00": `{"docType":"marble","version":1,"name":"m1","color":"blue","size":16,"user":"bob"}`},
+	}
+	if _, err := sc.Run(new(SimpleChaincode)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerformTradeFailureLeavesStateUnchanged(t *testing.T) {
+	cc := new(SimpleChaincode)
+	s, err := ledgertest.Scenario{
+		Name:     "open",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob", "leroy", "amy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "red", "35", "leroy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m3", "green", "35", "leroy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"bob", "red", "35", "blue", "16"}},
+		),
+	}.Run(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "tx000008-0"
+
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		setup func(s *ledgertest.MemStub)
+		code  string
+	}{
+		{"wrong opener", []string{id, "leroy", "m2", "nobody", "blue", "16"}, nil, CodeInvalidArg},
+		{"own trade", []string{id, "bob", "m1", "bob", "blue", "16"}, nil, CodeNotAllowed},
+		{"closer does not own marble", []string{id, "amy", "m2", "bob", "blue", "16"}, nil, CodeNotAllowed},
+		{"marble not wanted", []string{id, "leroy", "m3", "bob", "blue", "16"}, nil, CodeTradeMismatch},
+		{"not offered", []string{id, "leroy", "m2", "bob", "blue", "35"}, nil, CodeTradeMismatch},
+		{"unknown trade", []string{"nope", "leroy", "m2", "bob", "blue", "16"}, nil, CodeNotFound},
+		{"opener lost marble", []string{id, "leroy", "m2", "bob", "blue", "16"}, func(s *ledgertest.MemStub) {
+			s.State[marbleKey("m1")] = []byte(marbleState("m1", "blue", 16, "amy"))
+		}, CodeTradeMismatch},
+		{"corrupt opener marble", []string{id, "leroy", "m2", "bob", "blue", "16"}, func(s *ledgertest.MemStub) {
+			s.State[marbleKey("m1")] = []byte(`{"docType":"marble","version":1,"name":"m1","color":"blue","size":"big"}`)
+		}, CodeCorruptRecord},
+	} {
+		if tc.setup != nil {
+			tc.setup(s)
+		}
+		before := map[string]string{}
+		for _, key := range s.Keys() {
+			before[key] = string(s.Get(key))
+		}
+		events := len(s.Events)
+		_, err := s.Invoke(cc, "perform_trade", tc.args)
+		if !hasCode(err, tc.code) {
+			t.Errorf("%s: got %v, want %s", tc.name, err, tc.code)
+		}
+		if err := ledgertest.CheckState(s, before); err != nil || len(s.Keys()) != len(before) || len(s.Events) != events {
+			t.Errorf("%s: state changed: %v", tc.name, err)
+		}
+	}
+}
+
+func TestOpenTradeIgnoresCase(t *testing.T) {
+	trades := AllTrades{}
+	_, err := ledgertest.Scenario{
+		Name:     "case",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy", "bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "red", "16", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"Amy", "RED", "16", "Blue", "16"}, Check: decode(openTradesStr, &trades, func() error {
+				open := trades.OpenTrades[0]
+				if open.User != "amy" || open.Want.Color != "red" || open.Willing[0].Color != "blue" {
+					return fmt.Errorf("stored %+v", open)
+				}
+				return nil
+			})},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m3", "green", "1", "amy"}},
+			ledgertest.Step{Function: "set_user", Args: []string{"m3", "bob"}}, //cleans the trades, amy still has m2
+			ledgertest.Step{Function: "perform_trade", Args: []string{"tx000006-0", "bob", "m1", "AMY", "BLUE", "16"}},
+		),
+		State: map[string]string{
+			marbleKey("m1"): marbleState("m1", "red", 16, "amy"),
+			marbleKey("m2"): marbleState("m2", "blue", 16, "bob"),
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTradeIdsDoNotDependOnTheClock(t *testing.T) {
+	opened := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := ledgertest.NewFakeClock(opened)
+	timestamp := strconv.FormatInt(toMillis(opened), 10)
+	trades := AllTrades{}
+	_, err := ledgertest.Scenario{
+		Name:     "clock",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy", "bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "red", "16", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "16", "blue", "16"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "16", "blue", "16"}, Check: decode(openTradesStr, &trades, func() error {
+				for i, open := range trades.OpenTrades {
+					if open.Timestamp != toMillis(opened) || open.Id != fmt.Sprintf("tx%06d-0", 6+i) {
+						return fmt.Errorf("trade %d is %s opened at %d", i, open.Id, open.Timestamp)
+					}
+				}
+				return nil
+			})},
+			ledgertest.Step{Function: "perform_trade", Args: []string{timestamp, "bob", "m1", "amy", "blue", "16"}, WantErr: "matches more than one open trade"},
+			ledgertest.Step{Function: "perform_trade", Args: []string{"tx000007-0", "bob", "m1", "amy", "blue", "16"}},
+		),
+		State: map[string]string{
+			marbleKey("m1"): marbleState("m1", "red", 16, "amy"),
+			marbleKey("m2"): marbleState("m2", "blue", 16, "bob"),
+			openTradesStr:   `{"open_trades":[]}`, //amy no longer has a blue marble for the first trade
+		},
+	}.Run(&SimpleChaincode{clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanTradesErrorsAreReturned(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name:     "clean",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy", "bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "red", "16", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "16", "blue", "16"}},
+			ledgertest.Step{Function: "set_user", Args: []string{"m1", "amy"}, WantErr: `"code":"CORRUPT_RECORD"`, Setup: func(s *ledgertest.MemStub) {
+				s.State[marbleKey("m2")] = []byte(`{"docType":"marble","version":1,"name":"m2","color":"blue","size":"big"}`)
+			}},
+			ledgertest.Step{Function: "read", Args: []string{openTradesStr}, Query: true, Check: func(s *ledgertest.MemStub, res []byte) error {
+				var trades AllTrades
+				if json.Unmarshal(res, &trades) != nil || len(trades.OpenTrades) != 1 {
+					return fmt.Errorf("open trades: %s", res)
+				}
+				return nil
+			}},
+		),
+		State: map[string]string{
+			marbleKey("m1"): marbleState("m1", "red", 16, "bob"),
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// removedTrades - a Step.Check that the transaction emitted event name listing these trades as removed
+func removedTrades(name string, ids ...string) func(s *ledgertest.MemStub, res []byte) error {
+	return func(s *ledgertest.MemStub, res []byte) error {
+		last := s.Events[len(s.Events)-1]
+		var payload struct {
+			RemovedTrades []string `json:"removed_trades"`
+		}
+		json.Unmarshal(last.Payload, &payload)
+		if last.Name != name || fmt.Sprint(payload.RemovedTrades) != fmt.Sprint(ids) {
+			return fmt.Errorf("event %s %s, want %s removing %v", last.Name, last.Payload, name, ids)
+		}
+		return nil
+	}
+}
+
+func TestEventsListRemovedTrades(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name:     "removed trades",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy", "bob", "leroy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "red", "35", "bob"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m3", "green", "1", "amy"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "35", "blue", "16"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "35", "green", "1"}},
+			ledgertest.Step{Function: "set_user", Args: []string{"m1", "leroy"}, Check: removedTrades(eventOwnerChanged, "tx000008-0")},
+			ledgertest.Step{Function: "delete", Args: []string{"m3"}, Check: removedTrades(eventMarbleDeleted, "tx000009-0")},
+			ledgertest.Step{Function: "open_trade", Args: []string{"leroy", "red", "35", "blue", "16"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"leroy", "yellow", "1", "blue", "16"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m4", "green", "2", "bob"}, Check: removedTrades(eventMarbleCreated)},
+			ledgertest.Step{Function: "perform_trade", Args: []string{"tx000012-0", "bob", "m2", "leroy", "blue", "16"}, Check: removedTrades(eventTradeCompleted, "tx000013-0")},
+			ledgertest.Step{Function: "set_user", Args: []string{"m4", "amy"}, Check: removedTrades(eventOwnerChanged)},
+			ledgertest.Step{Function: "read", Args: []string{openTradesStr}, Query: true, Want: `{"open_trades":[]}`},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
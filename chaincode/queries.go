@@ -0,0 +1,251 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Page sizes of the paginated queries
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// MarblePage is one page of a marble listing. Bookmark is passed back to get
+// the next page, it is empty on the last page.
+type MarblePage struct {
+	Marbles  []Marble `json:"marbles"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// ============================================================================================================================
+// scanMarbles - call fn with every marble from the one named start on, in name order, until fn returns false
+// ============================================================================================================================
+func scanMarbles(stub shim.ChaincodeStubInterface, start string, fn func(marble Marble) bool) error {
+	startKey, endKey := namespaceRange(nsMarble)
+	if start != "" {
+		startKey = marbleKey(start)
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return ledgerError("Failed to scan marbles")
+	}
+	defer keysIter.Close()
+
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return ledgerError("Failed to scan marbles")
+		}
+		res := Marble{}
+		if json.Unmarshal(valAsBytes, &res) != nil {
+			fmt.Println("! skipping corrupt marble " + printableKey(key))
+			continue
+		}
+		if !fn(res) {
+			break
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// List Marbles - query, one page of marbles in name order
+// ============================================================================================================================
+func (t *SimpleChaincode) list_marbles(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1              2
+	// "page_size", "bookmark", "include_retired"     all optional, bookmark is the one returned with the previous page
+	limit, err := pageSize(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	retired, err := includeRetired(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	page := MarblePage{Marbles: []Marble{}}
+	err = scanMarbles(stub, bookmark, func(marble Marble) bool {
+		if marble.Retired != nil && !retired {
+			return true
+		}
+		if len(page.Marbles) == limit {
+			page.Bookmark = marble.Name //first marble of the next page
+			return false
+		}
+		page.Marbles = append(page.Marbles, marble)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// Marbles By Owner - query, one page of the marbles a user owns, in name order
+// ============================================================================================================================
+func (t *SimpleChaincode) marbles_by_owner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0          1            2              3
+	// "bob", "page_size", "bookmark", "include_retired"     all but the user are optional
+	start := makeKey(nsMarbleByOwner, strings.ToLower(args[0]))
+	return indexPage(stub, start, start+lastKey, args, 1)
+}
+
+// ============================================================================================================================
+// Marbles By Color - query, one page of the marbles of a color, smallest first
+// ============================================================================================================================
+func (t *SimpleChaincode) marbles_by_color(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0           1            2              3
+	// "blue", "page_size", "bookmark", "include_retired"     all but the color are optional
+	start := makeKey(nsMarbleByColor, strings.ToLower(args[0]))
+	return indexPage(stub, start, start+lastKey, args, 1)
+}
+
+// ============================================================================================================================
+// Marbles In Size Range - query, one page of the marbles from min to max size, both inclusive, smallest first
+// ============================================================================================================================
+func (t *SimpleChaincode) marbles_in_size_range(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//   0     1        2            3              4
+	// "16", "35", "page_size", "bookmark", "include_retired"     all but the sizes are optional
+	min, _ := strconv.Atoi(args[0]) //checked by the schema
+	max, _ := strconv.Atoi(args[1])
+	if min > max {
+		return nil, argError(1, "Max size must not be less than min size")
+	}
+	return indexPage(stub, makeKey(nsMarbleBySize, sizeKey(min)), makeKey(nsMarbleBySize, sizeKey(max))+lastKey, args, 2)
+}
+
+// ============================================================================================================================
+// indexPage - one page of the marbles whose index keys lie between start and end, both inclusive. args[i] is the page
+// size, args[i+1] the bookmark and args[i+2] whether retired marbles are included, see indexScan.
+// ============================================================================================================================
+func indexPage(stub shim.ChaincodeStubInterface, start string, end string, args []string, i int) ([]byte, error) {
+	retired, err := includeRetired(args, i+2)
+	if err != nil {
+		return nil, err
+	}
+	page := MarblePage{Marbles: []Marble{}}
+	page.Bookmark, err = indexScan(stub, start, end, args, i, func(parts []string, full bool) (bool, error) {
+		res := Marble{}
+		found, err := getRecord(stub, marbleKey(parts[len(parts)-1]), docMarble, &res)
+		if err != nil || !found || (res.Retired != nil && !retired) {
+			return false, err
+		}
+		if !full {
+			page.Marbles = append(page.Marbles, res)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// indexScan - walk the index keys between start and end, both inclusive, until a page is full. args[i] is the page
+// size and args[i+1] the bookmark, the opaque key of the first entry of the page, when given. add gets the id parts of
+// each entry and tells whether the entry belongs on the page, putting it there unless full is set. Once the page is
+// full the next entry that belongs is the bookmark of the next page, which is empty on the last page.
+// ============================================================================================================================
+func indexScan(stub shim.ChaincodeStubInterface, start string, end string, args []string, i int, add func(parts []string, full bool) (bool, error)) (string, error) {
+	limit, err := pageSize(args, i)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > i+1 && args[i+1] != "" {
+		key, err := base64.RawURLEncoding.DecodeString(args[i+1])
+		if err != nil || string(key) < start || string(key) > end {
+			return "", argError(i+1, "Bookmark does not belong to this query")
+		}
+		start = string(key)
+	}
+
+	keysIter, err := stub.RangeQueryState(start, end)
+	if err != nil {
+		return "", ledgerError("Failed to scan index")
+	}
+	defer keysIter.Close()
+
+	added := 0
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return "", ledgerError("Failed to scan index")
+		}
+		_, parts, ok := splitKey(key)
+		if !ok || len(parts) == 0 {
+			continue
+		}
+		ok, err = add(parts, added == limit)
+		if err != nil {
+			return "", err
+		}
+		if ok && added == limit {
+			return base64.RawURLEncoding.EncodeToString([]byte(key)), nil //first entry of the next page
+		}
+		if ok {
+			added++
+		}
+	}
+	return "", nil
+}
+
+// pageSize - the page size in args[i], defaultPageSize when it is missing or empty
+func pageSize(args []string, i int) (int, error) {
+	if len(args) <= i || args[i] == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(args[i])
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, argError(i, "Page size must be between 1 and "+strconv.Itoa(maxPageSize))
+	}
+	return limit, nil
+}
+
+// ============================================================================================================================
+// marbleIndexView - the names of every marble, the shape of the _marbleindex array clients used to read
+//
+// The index is no longer stored, every create and delete rewrote the whole array and serialized all writers on one
+// key. read builds it on demand so older clients keep working, new clients should page through list_marbles.
+// ============================================================================================================================
+func marbleIndexView(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	names := []string{}
+	err := scanMarbles(stub, "", func(marble Marble) bool {
+		if marble.Retired == nil { //deleted marbles were never in the old index
+			names = append(names, marble.Name)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(names)
+}
@@ -0,0 +1,202 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// marbleLedger - a ledger with marbles a to e, d retired
+//
+//	a blue 16 amy, b blue 35 bob, c red 16 amy, d blue 16 amy (retired), e green 50 amy
+func marbleLedger(t *testing.T) *ledgertest.MemStub {
+	s, err := ledgertest.Scenario{
+		Name:     "marbles",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy", "bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"e", "green", "50", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"c", "red", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"a", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"d", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"b", "blue", "35", "bob"}},
+			ledgertest.Step{Function: "delete", Args: []string{"d", "lost"}},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// marblePages - the names on every page of a marble query, following the bookmarks. args go before the page size,
+// retired is the include_retired argument.
+func marblePages(s *ledgertest.MemStub, function string, args []string, size string, retired string) (string, error) {
+	var pages []string
+	bookmark := ""
+	for len(pages) < 10 {
+		res, err := s.Query(new(SimpleChaincode), function, append(append([]string{}, args...), size, bookmark, retired))
+		if err != nil {
+			return "", err
+		}
+		page := MarblePage{}
+		if err := json.Unmarshal(res, &page); err != nil {
+			return "", err
+		}
+		names := ""
+		for _, marble := range page.Marbles {
+			names += marble.Name
+		}
+		pages = append(pages, names)
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	return fmt.Sprint(pages), nil
+}
+
+func TestIndexQueriesPage(t *testing.T) {
+	s := marbleLedger(t)
+	for _, tc := range []struct {
+		function string
+		args     []string
+		size     string
+		retired  string
+		want     string
+	}{
+		{"marbles_by_owner", []string{"amy"}, "", "", "[ace]"},
+		{"marbles_by_owner", []string{"AMY"}, "2", "", "[ac e]"},
+		{"marbles_by_owner", []string{"amy"}, "2", "true", "[ac de]"},
+		{"marbles_by_owner", []string{"amy"}, "3", "", "[ace]"},
+		{"marbles_by_owner", []string{"amy"}, "1", "false", "[a c e]"},
+		{"marbles_by_owner", []string{"nobody"}, "1", "", "[]"},
+		{"marbles_by_color", []string{"blue"}, "1", "", "[a b]"}, //smallest first, d is retired
+		{"marbles_by_color", []string{"Blue"}, "1", "true", "[a d b]"},
+		{"marbles_by_color", []string{"blue"}, "2", "", "[ab]"},
+		{"marbles_in_size_range", []string{"16", "16"}, "2", "", "[ac]"}, //d is the only one left, no empty page after
+		{"marbles_in_size_range", []string{"16", "16"}, "2", "true", "[ac d]"},
+		{"marbles_in_size_range", []string{"16", "50"}, "3", "", "[acb e]"},
+		{"marbles_in_size_range", []string{"17", "34"}, "", "", "[]"},
+	} {
+		got, err := marblePages(s, tc.function, tc.args, tc.size, tc.retired)
+		if err != nil || got != tc.want {
+			t.Errorf("%s%v size %q retired %q: got %s %v, want %s", tc.function, tc.args, tc.size, tc.retired, got, err, tc.want)
+		}
+	}
+}
+
+func TestIndexQueryArguments(t *testing.T) {
+	s := marbleLedger(t)
+	res, err := s.Query(new(SimpleChaincode), "marbles_by_owner", []string{"amy", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := MarblePage{}
+	json.Unmarshal(res, &page)
+	for _, tc := range []struct {
+		function string
+		args     []string
+		code     string
+	}{
+		{"marbles_by_color", []string{"blue", "1", page.Bookmark}, CodeInvalidArg}, //a bookmark of another query
+		{"marbles_by_owner", []string{"amy", "1", "not a bookmark!"}, CodeInvalidArg},
+		{"marbles_by_owner", []string{"amy", "0"}, CodeInvalidArg},
+		{"marbles_by_owner", []string{"amy", "201"}, CodeInvalidArg},
+		{"marbles_by_owner", []string{"amy", "1", "", "maybe"}, CodeValidation},
+		{"marbles_in_size_range", []string{"35", "16"}, CodeInvalidArg},
+	} {
+		if _, err := s.Query(new(SimpleChaincode), tc.function, tc.args); !hasCode(err, tc.code) {
+			t.Errorf("%s%v: got %v, want %s", tc.function, tc.args, err, tc.code)
+		}
+	}
+}
+
+func TestReadMarbleIndex(t *testing.T) {
+	s := marbleLedger(t)
+	res, err := s.Query(new(SimpleChaincode), "read", []string{"_marbleindex"})
+	if err != nil || !ledgertest.Equal(res, []byte(`["a","b","c","e"]`)) {
+		t.Errorf("_marbleindex: got %s %v", res, err)
+	}
+}
+
+func TestListMarblesPages(t *testing.T) {
+	s := marbleLedger(t)
+	for _, tc := range []struct {
+		size    string
+		retired string
+		want    string
+	}{
+		{"", "", "[abce]"},
+		{"2", "", "[ab ce]"},
+		{"4", "", "[abce]"}, //the last page is full, no bookmark
+		{"3", "", "[abc e]"},
+		{"1", "", "[a b c e]"},
+		{"2", "true", "[ab cd e]"},
+		{"4", "true", "[abcd e]"},
+		{"5", "true", "[abcde]"},
+	} {
+		got, err := marblePages(s, "list_marbles", nil, tc.size, tc.retired)
+		if err != nil || got != tc.want {
+			t.Errorf("size %q retired %q: got %s %v, want %s", tc.size, tc.retired, got, err, tc.want)
+		}
+	}
+	for _, size := range []string{"0", "201", "-1"} {
+		if _, err := s.Query(new(SimpleChaincode), "list_marbles", []string{size}); !hasCode(err, CodeInvalidArg) {
+			t.Errorf("size %s: got %v", size, err)
+		}
+	}
+}
+
+// TestListMarblesAsTheAppPages follows bookmarks the way app.js does, an empty page size then the bookmark
+func TestListMarblesAsTheAppPages(t *testing.T) {
+	s := marbleLedger(t)
+	cc := new(SimpleChaincode)
+	for i := 0; i < defaultPageSize+1; i++ {
+		if _, err := s.Invoke(cc, "init_marble", []string{fmt.Sprintf("m%03d", i), "white", "1", "bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]bool{}
+	pages := 0
+	for bookmark := ""; pages == 0 || bookmark != ""; pages++ {
+		res, err := s.Query(cc, "list_marbles", []string{"", bookmark})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := MarblePage{}
+		json.Unmarshal(res, &page)
+		for _, marble := range page.Marbles {
+			if seen[marble.Name] {
+				t.Fatalf("%s is on two pages", marble.Name)
+			}
+			seen[marble.Name] = true
+		}
+		if page.Bookmark != "" && len(page.Marbles) != defaultPageSize {
+			t.Fatalf("page %d has %d marbles and a bookmark", pages, len(page.Marbles))
+		}
+		bookmark = page.Bookmark
+	}
+	if pages != 2 || len(seen) != defaultPageSize+5 {
+		t.Errorf("%d pages, %d marbles", pages, len(seen))
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// recordVersion is the version stamped on every record written by this chaincode
+const recordVersion = 1
+
+// Record types, stored in the docType field of every record
+const (
+	docMarble  = "marble"
+	docDriver  = "driver"
+	docBooking = "booking"
+	docAdmin   = "admin"
+)
+
+// Record is the envelope embedded in every record type. Its fields are stored
+// next to the record's own fields so existing clients can keep reading them.
+type Record struct {
+	DocType string `json:"docType,omitempty"` //kind of record, one of the doc* constants
+	Version int    `json:"version,omitempty"` //recordVersion when written, 0 for legacy records
+}
+
+func (r *Record) header() *Record {
+	return r
+}
+
+// record is any struct that embeds Record
+type record interface {
+	header() *Record
+}
+
+// ============================================================================================================================
+// putRecord - stamp the envelope on a record, marshal it and store it under key
+// ============================================================================================================================
+func putRecord(stub shim.ChaincodeStubInterface, key string, docType string, rec record) error {
+	h := rec.header()
+	h.DocType = docType
+	h.Version = recordVersion
+	jsonAsBytes, err := json.Marshal(rec)
+	if err != nil {
+		return newError(CodeInternal, "Failed to marshal "+printableKey(key))
+	}
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return ledgerError("Failed to write " + printableKey(key))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getRecord - read the record stored under key into rec, found is false if there is no such key
+// ============================================================================================================================
+func getRecord(stub shim.ChaincodeStubInterface, key string, docType string, rec record) (found bool, err error) {
+	valAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, ledgerError("Failed to get " + printableKey(key))
+	}
+	if len(valAsBytes) == 0 {
+		return false, nil
+	}
+	if json.Unmarshal(valAsBytes, rec) != nil {
+		return true, newError(CodeCorruptRecord, "Stored "+printableKey(key)+" is corrupt, run repair_records")
+	}
+	return true, nil
+}
+
+// ============================================================================================================================
+// Repair Records - one time fix of records written before they were marshalled with encoding/json
+//
+// Records used to be built by string concatenation, so a value holding a quote or backslash produced invalid json.
+// Every key is scanned, unparsable records are rebuilt from the layout they were concatenated with, and parsable
+// records without an envelope get one. Returns a report of what was done.
+// ============================================================================================================================
+func (t *SimpleChaincode) repair_records(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start repair records")
+	var report RepairReport
+
+	keysIter, err := stub.RangeQueryState("", lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan keys")
+	}
+	defer keysIter.Close()
+
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan keys")
+		}
+		if isSystemKey(key) {
+			continue
+		}
+		report.Scanned++
+
+		var fields map[string]interface{}
+		if json.Unmarshal(valAsBytes, &fields) == nil {
+			if _, ok := fields["docType"]; ok || fields == nil {
+				continue //already current, or not one of our records
+			}
+			rec, docType := classifyRecord(fields)
+			if rec == nil {
+				continue
+			}
+			json.Unmarshal(valAsBytes, rec)
+			if err := putRecord(stub, key, docType, rec); err != nil {
+				return nil, err
+			}
+			report.Upgraded = append(report.Upgraded, printableKey(key))
+			continue
+		}
+
+		rec, docType := rebuildRecord(string(valAsBytes))
+		if rec == nil {
+			fmt.Println("! can not repair " + printableKey(key))
+			report.Unrepairable = append(report.Unrepairable, printableKey(key))
+			continue
+		}
+		if err := putRecord(stub, key, docType, rec); err != nil {
+			return nil, err
+		}
+		fmt.Println("! repaired " + printableKey(key))
+		report.Repaired = append(report.Repaired, printableKey(key))
+	}
+
+	fmt.Println("- end repair records")
+	return json.Marshal(report)
+}
+
+// RepairReport is the result of repair_records
+type RepairReport struct {
+	Scanned      int      `json:"scanned"`      //records looked at
+	Repaired     []string `json:"repaired"`     //keys that did not parse and were rebuilt
+	Upgraded     []string `json:"upgraded"`     //keys that parsed but had no envelope
+	Unrepairable []string `json:"unrepairable"` //keys that did not parse and match no known layout
+}
+
+// lastKey sorts after every key a client can create, used as the end of full scans
+const lastKey = "\U0010FFFF"
+
+// isSystemKey - keys that hold indexes and test values rather than records
+func isSystemKey(key string) bool {
+	return strings.HasPrefix(key, "_") || key == "abc" || isIndexKey(key)
+}
+
+// classifyRecord - guess the type of a legacy record from its fields
+func classifyRecord(fields map[string]interface{}) (record, string) {
+	has := func(name string) bool {
+		_, ok := fields[name]
+		return ok
+	}
+	switch {
+	case has("bookacaremail"):
+		return &Bookcar{}, docBooking
+	case has("dl") && has("email"):
+		return &Driver{}, docDriver
+	case has("color") && has("size"):
+		return &Marble{}, docMarble
+	case has("userid") && has("password"):
+		return &Adminlogin{}, docAdmin
+	}
+	return nil, ""
+}
+
+// legacyLayout is the exact string a record type used to be concatenated from,
+// copied from the first version of init_marble, signup_driver, book_car and
+// Init. Literals[i] precedes field Fields[i], the last literal closes the record.
+type legacyLayout struct {
+	DocType  string
+	Literals []string
+	Fields   []string
+}
+
+var legacyLayouts = []legacyLayout{
+	{docMarble,
+		[]string{`{"name": "`, `", "color": "`, `", "size": `, `, "user": "`, `"}`},
+		[]string{"name", "color", "size", "user"}},
+	{docDriver,
+		[]string{`{"name": "`, `", "dl": "`, `", "dob": "`, `", "email": "`, `",  "mobile": "`, `", "password": "`, `","address": "`, `","status": "`, `","modifyby": "`, `" ,"adminemail": "`, `" ,"rejectreason": "`, `" ,"anycomment": "`, `"}`},
+		[]string{"name", "dl", "dob", "email", "mobile", "password", "address", "status", "modifyby", "adminemail", "rejectreason", "anycomment"}},
+	{docBooking,
+		[]string{`{"bookacarname": "`, `", "bookacaremail": "`, `", "bookacarclass": "`, `","bookacarlocation": "`, `", "bookacardroplocation": "`, `","bookacarpickupdate": "`, `", "bookacarpickuptime": "`, `", "bookacardropoffdate": "`, `", "bookacardropofftime": "`, `","bookingid": "`, `"}`},
+		[]string{"bookacarname", "bookacaremail", "bookacarclass", "bookacarlocation", "bookacardroplocation", "bookacarpickupdate", "bookacarpickuptime", "bookacardropoffdate", "bookacardropofftime", "bookingid"}},
+	{docAdmin,
+		[]string{`{"userid": "`, `", "password": "`, `"}`},
+		[]string{"userid", "password"}},
+}
+
+// ============================================================================================================================
+// rebuildRecord - recover the fields of a concatenated record that is not valid json
+// ============================================================================================================================
+func rebuildRecord(str string) (record, string) {
+	for _, layout := range legacyLayouts {
+		values, ok := layout.split(str)
+		if !ok {
+			continue
+		}
+		switch layout.DocType {
+		case docMarble:
+			size, err := strconv.Atoi(values["size"])
+			if err != nil {
+				continue
+			}
+			return &Marble{Name: values["name"], Color: values["color"], Size: size, User: values["user"]}, docMarble
+		case docDriver:
+			return &Driver{Name: values["name"], DL: values["dl"], DOB: values["dob"], Email: values["email"],
+				Mobile: values["mobile"], Password: values["password"], Address: values["address"],
+				Status: values["status"], Modifyby: values["modifyby"], Adminemail: values["adminemail"],
+				Rejectreason: values["rejectreason"], Anycomment: values["anycomment"]}, docDriver
+		case docBooking:
+			return &Bookcar{Bookacarname: values["bookacarname"], Bookacaremail: values["bookacaremail"],
+				Bookacarclass: values["bookacarclass"], Bookacarlocation: values["bookacarlocation"],
+				Bookacardroplocation: values["bookacardroplocation"], Bookacarpickupdate: values["bookacarpickupdate"],
+				Bookacarpickuptime: values["bookacarpickuptime"], Bookacardropoffdate: values["bookacardropoffdate"],
+				Bookacardropofftime: values["bookacardropofftime"], Bookingid: values["bookingid"]}, docBooking
+		case docAdmin:
+			return &Adminlogin{Userid: values["userid"], Password: values["password"]}, docAdmin
+		}
+	}
+	return nil, ""
+}
+
+// split - cut str into the layout's fields, ok is false if str was not built from this layout
+func (l legacyLayout) split(str string) (map[string]string, bool) {
+	first, last := l.Literals[0], l.Literals[len(l.Literals)-1]
+	if !strings.HasPrefix(str, first) || !strings.HasSuffix(str, last) {
+		return nil, false
+	}
+	rest := str[len(first) : len(str)-len(last)]
+	values := map[string]string{}
+	for i, field := range l.Fields {
+		if i == len(l.Fields)-1 {
+			values[field] = rest
+			break
+		}
+		pos := strings.Index(rest, l.Literals[i+1])
+		if pos < 0 {
+			return nil, false
+		}
+		values[field] = rest[:pos]
+		rest = rest[pos+len(l.Literals[i+1]):]
+	}
+	return values, true
+}
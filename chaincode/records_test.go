@@ -0,0 +1,142 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// The legacy* functions build records the way the first version of the chaincode stored them, each concatenation
+// copied from the function named in its comment.
+
+// legacyMarble - init_marble
+func legacyMarble(name string, color string, size int, user string) string {
+	return `{"name": "` + name + `", "color": "` + color + `", "size": ` + strconv.Itoa(size) + `, "user": "` + user + `"}`
+}
+
+// legacyDriver - signup_driver
+func legacyDriver(name, dl, dob, email, mobile, password, address, status, modifyby, adminemail, rejectreason, anycomment string) string {
+	return `{"name": "` + name + `", "dl": "` + dl + `", "dob": "` + dob + `", "email": "` + email + `",  "mobile": "` + mobile + `", "password": "` + password + `","address": "` + address + `","status": "` + status + `","modifyby": "` + modifyby + `" ,"adminemail": "` + adminemail + `" ,"rejectreason": "` + rejectreason + `" ,"anycomment": "` + anycomment + `"}`
+}
+
+// legacyBooking - book_car
+func legacyBooking(bookacarname, bookacaremail, bookacarclass, bookacarlocation, bookacardroplocation, bookacarpickupdate, bookacarpickuptime, bookacardropoffdate, bookacardropofftime, bookingid string) string {
+	return `{"bookacarname": "` + bookacarname + `", "bookacaremail": "` + bookacaremail + `", "bookacarclass": "` + bookacarclass + `","bookacarlocation": "` + bookacarlocation + `", "bookacardroplocation": "` + bookacardroplocation + `","bookacarpickupdate": "` + bookacarpickupdate + `", "bookacarpickuptime": "` + bookacarpickuptime + `", "bookacardropoffdate": "` + bookacardropoffdate + `", "bookacardropofftime": "` + bookacardropofftime + `","bookingid": "` + bookingid + `"}`
+}
+
+// legacyAdmin - Init
+func legacyAdmin(userid string, password string) string {
+	return `{"userid": "` + userid + `", "password": "` + password + `"}`
+}
+
+func TestRepairRecords(t *testing.T) {
+	s := ledgertest.NewMemStub()
+	asOperator(s)
+	cc := new(SimpleChaincode)
+	legacy := map[string]string{
+		`say "hi"`:      legacyMarble(`say "hi"`, "blue", 16, "bob"),
+		`back\slash`:    legacyMarble(`back\slash`, "red", 35, "amy"),
+		"plain":         legacyMarble("plain", "green", 5, "bob"),
+		"d@x.com":       legacyDriver("D", "dl", "1990-01-01", "d@x.com", "1", `p"w\`, `1 "Main" St`, "", "", "", "", `C:\temp`),
+		"d@x.comb1":     legacyBooking("D", "d@x.com", "suv", `Gate "A"`, "there", "2016-02-01", "10:00", "2016-02-02", "10:00", "b1"),
+		"admin@x.com":   legacyAdmin("admin@x.com", `"quoted"`),
+		"junk":          `{"name": "junk`,
+		"_marbleindex":  `["say "hi""]`, //system keys are left alone
+		"abc":           "42",
+		"not ours":      `{"anything": 1}`,
+		marbleKey("m1"): marbleState("m1", "blue", 16, "bob"),
+	}
+	for key, value := range legacy {
+		s.State[key] = []byte(value)
+	}
+
+	res, err := s.Invoke(cc, "repair_records", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RepairReport{
+		Scanned:      9,
+		Repaired:     []string{"admin@x.com", `back\slash`, "d@x.com", "d@x.comb1", `say "hi"`},
+		Upgraded:     []string{"plain"},
+		Unrepairable: []string{"junk"},
+	}
+	var got RepairReport
+	if json.Unmarshal(res, &got) != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("report %s, want %+v", res, want)
+	}
+
+	err = ledgertest.CheckState(s, map[string]string{
+		`say "hi"`:     `{"docType":"marble","version":1,"name":"say \"hi\"","color":"blue","size":16,"user":"bob"}`,
+		`back\slash`:   `{"docType":"marble","version":1,"name":"back\\slash","color":"red","size":35,"user":"amy"}`,
+		"plain":        `{"docType":"marble","version":1,"name":"plain","color":"green","size":5,"user":"bob"}`,
+		"admin@x.com":  `{"docType":"admin","version":1,"userid":"admin@x.com","password":"\"quoted\""}`,
+		"junk":         legacy["junk"],
+		"_marbleindex": legacy["_marbleindex"],
+		"abc":          "42",
+		"not ours":     `{"anything": 1}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := Driver{}
+	getRecord(s, "d@x.com", docDriver, &driver)
+	if driver.Password != `p"w\` || driver.Address != `1 "Main" St` || driver.Anycomment != `C:\temp` || driver.Email != "d@x.com" || driver.DocType != docDriver {
+		t.Errorf("driver %+v", driver)
+	}
+	booking := Bookcar{}
+	getRecord(s, "d@x.comb1", docBooking, &booking)
+	if booking.Bookacarlocation != `Gate "A"` || booking.Bookingid != "b1" || booking.Bookacardropoffdate != "2016-02-02" || booking.DocType != docBooking {
+		t.Errorf("booking %+v", booking)
+	}
+
+	//a second run finds nothing left to do
+	before := map[string]string{}
+	for _, key := range s.Keys() {
+		before[key] = string(s.Get(key))
+	}
+	res, err = s.Invoke(cc, "repair_records", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = RepairReport{}
+	json.Unmarshal(res, &got)
+	if len(got.Repaired) != 0 || len(got.Upgraded) != 0 || !reflect.DeepEqual(got.Unrepairable, []string{"junk"}) {
+		t.Errorf("second run %s", res)
+	}
+	if err := ledgertest.CheckState(s, before); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitNeedsTheWholeLayout(t *testing.T) {
+	marble := legacyMarble("m", "blue", 16, "bob")
+	for _, str := range []string{
+		marble[:len(marble)-2],                          //cut short
+		`{"name": "m", "color": "blue", "user": "bob"}`, //a field missing
+		`{"name": "m", "color": "blue", "size": big, "user": "bob"}`,
+	} {
+		if rec, _ := rebuildRecord(str); rec != nil {
+			t.Errorf("%s rebuilt as %+v", str, rec)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Deleting a marble only retires it. The record stays under its key, and in
+// the secondary indexes, with Retired set; listings skip it unless asked to
+// include retired marbles, and it can not change owner or be traded. The
+// owner can restore it, an operator can purge it for good.
+
+// Retirement records who deleted a marble, when and why
+type Retirement struct {
+	By        string `json:"by"`        //username attribute of the caller, empty if the certificate has none
+	Timestamp int64  `json:"timestamp"` //utc timestamp of the deletion, in milliseconds
+	Reason    string `json:"reason"`
+}
+
+func (t *SimpleChaincode) newRetirement(stub shim.ChaincodeStubInterface, reason string) (*Retirement, error) {
+	timestamp, err := t.makeTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &Retirement{By: getCaller(stub).Id, Timestamp: timestamp, Reason: reason}, nil
+}
+
+// getRetiredMarble - the marble called name, args[0], which must exist and be retired
+func getRetiredMarble(stub shim.ChaincodeStubInterface, name string) (Marble, error) {
+	res := Marble{}
+	found, err := getRecord(stub, marbleKey(name), docMarble, &res)
+	if err != nil {
+		return res, err
+	}
+	if !found {
+		return res, &ChaincodeError{Code: CodeNotFound, Message: "Marble " + name + " does not exist", Arg: 0}
+	}
+	if res.Retired == nil {
+		return res, &ChaincodeError{Code: CodeNotAllowed, Message: "Marble " + name + " is not retired, delete it first", Arg: 0}
+	}
+	return res, nil
+}
+
+// ============================================================================================================================
+// Restore Marble - bring a deleted marble back to its owner
+// ============================================================================================================================
+func (t *SimpleChaincode) restore_marble(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "name"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	fmt.Println("- start restore marble")
+	res, err := getRetiredMarble(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = requireActiveUser(stub, res.User, NoArg) //the marble goes back to its owner, who must still be active
+	if err != nil {
+		return nil, err
+	}
+	res.Retired = nil
+	err = putRecord(stub, marbleKey(args[0]), docMarble, &res)
+	if err != nil {
+		return nil, err
+	}
+	err = t.appendHistory(stub, args[0], "", res.User, reasonRestored, "")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end restore marble")
+	return nil, emitEvent(stub, eventMarbleRestored, MarbleEvent{Marble: res})
+}
+
+// ============================================================================================================================
+// Purge Marble - permanently remove a deleted marble and its index entries, its history is kept
+// ============================================================================================================================
+func (t *SimpleChaincode) purge_marble(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "name"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	fmt.Println("- start purge marble")
+	res, err := getRetiredMarble(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = unindexMarble(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(marbleKey(args[0]))
+	if err != nil {
+		return nil, ledgerError("Failed to delete marble " + args[0])
+	}
+	err = t.appendHistory(stub, args[0], res.User, "", reasonPurged, "")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end purge marble")
+	return nil, emitEvent(stub, eventMarblePurged, MarbleEvent{Marble: res})
+}
+
+// includeRetired - the include_retired flag in args[i], false when it is missing or empty
+func includeRetired(args []string, i int) (bool, error) {
+	if len(args) <= i || args[i] == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(args[i])
+	if err != nil {
+		return false, argError(i, "include_retired must be true or false")
+	}
+	return include, nil
+}
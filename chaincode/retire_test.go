@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+func TestDeleteRefusesWhatIsNotALiveMarble(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name:     "delete",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob"),
+			ledgertest.Step{Function: "delete", Args: []string{openTradesStr}, WantErr: `"code":"NOT_ALLOWED"`},
+			ledgertest.Step{Function: "delete_marble", Args: []string{"_marbleindex"}, WantErr: `"code":"NOT_ALLOWED"`},
+			ledgertest.Step{Function: "delete", Args: []string{"nope"}, WantErr: `"code":"NOT_FOUND"`},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "delete", Args: []string{"m1", "lost"}},
+			ledgertest.Step{Function: "delete", Args: []string{"m1", "lost"}, WantErr: "is already retired"},
+			ledgertest.Step{Function: "read", Args: []string{openTradesStr}, Query: true, Want: `{"open_trades":[]}`},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetiredMarblesAreHiddenUnlessAsked(t *testing.T) {
+	s, err := ledgertest.Scenario{
+		Name:     "retire",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("amy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"a", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"b", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"c", "blue", "16", "amy"}},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//list amy's blue 16 marbles every way, two to a page. Retired marbles are only listed when include_retired is true.
+	check := func(when string, live string, all string) {
+		for _, retired := range []string{"", "false", "true"} {
+			want := live
+			if retired == "true" {
+				want = all
+			}
+			for _, query := range []struct {
+				function string
+				args     []string
+			}{
+				{"list_marbles", nil},
+				{"marbles_by_owner", []string{"amy"}},
+				{"marbles_by_color", []string{"blue"}},
+				{"marbles_in_size_range", []string{"16", "16"}},
+			} {
+				got, err := marblePages(s, query.function, query.args, "2", retired)
+				if err != nil || got != want {
+					t.Errorf("%s, %s include_retired %q: got %s %v, want %s", when, query.function, retired, got, err, want)
+				}
+			}
+		}
+	}
+
+	check("before retiring", "[ab c]", "[ab c]")
+	asOperator(s)
+	if _, err := s.Invoke(new(SimpleChaincode), "delete", []string{"b", "lost"}); err != nil {
+		t.Fatal(err)
+	}
+	check("after retiring b", "[ac]", "[ab c]")
+	if _, err := s.Invoke(new(SimpleChaincode), "restore_marble", []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	check("after restoring b", "[ab c]", "[ab c]")
+}
+
+func TestIncludeRetired(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want bool
+		ok   bool
+	}{
+		{nil, false, true},
+		{[]string{"10", ""}, false, true},
+		{[]string{"10", "", ""}, false, true},
+		{[]string{"10", "", "false"}, false, true},
+		{[]string{"10", "", "true"}, true, true},
+		{[]string{"10", "", "1"}, true, true},
+		{[]string{"10", "", "maybe"}, false, false},
+	} {
+		got, err := includeRetired(tc.args, 2)
+		if got != tc.want || (err == nil) != tc.ok || (err != nil && !hasCode(err, CodeInvalidArg)) {
+			t.Errorf("%q: got %v %v, want %v", tc.args, got, err, tc.want)
+		}
+	}
+}
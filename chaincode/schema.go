@@ -0,0 +1,424 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Argument types and formats used in the schemas below.
+const (
+	typeString = "string"
+	typeInt    = "int"
+	typeBool   = "bool"
+
+	formatEmail   = "email"
+	formatDate    = "date"
+	formatISODate = "isodate" //only 2016-12-31, 01/02/2016 could be the 2nd of January or the 1st of February
+	formatTime    = "time"
+)
+
+// argSpec describes one positional argument of a chaincode function.
+type argSpec struct {
+	Name     string
+	Required bool   //must be a non-empty string
+	Type     string //typeString, typeInt or typeBool, empty means typeString
+	Format   string //optional format checked when the value is not empty
+}
+
+// argSchema describes all arguments of a chaincode function. Args are the
+// fixed leading arguments, the last Optional of them may be left off, such as
+// the page size and bookmark of a listing. Repeat, when set, is a group of
+// arguments that may follow Args any number of times, at least MinRepeat
+// times, such as the color/size pairs of open_trade.
+type argSchema struct {
+	Args      []argSpec
+	Optional  int
+	Repeat    []argSpec
+	MinRepeat int
+}
+
+// Problem is one thing wrong with the arguments of a call.
+type Problem struct {
+	Arg     int    `json:"arg"` //index of the argument, NoArg when it is about the count
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// ============================================================================================================================
+// Schemas - the arguments of every Invoke and Query function, checked before the function runs
+// ============================================================================================================================
+var invokeSchemas = map[string]argSchema{
+	"reset_ledger": {},
+	"delete": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "reason"},
+	}, Optional: 1},
+	"delete_marble": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "reason"},
+	}, Optional: 1},
+	"delete_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+	}},
+	"cancel_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+		{Name: "reason"},
+	}, Optional: 1},
+	"confirm_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+	}},
+	"pickup_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+	}},
+	"return_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+	}},
+	"no_show_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+	}},
+	"restore_marble": {Args: []argSpec{
+		{Name: "name", Required: true},
+	}},
+	"purge_marble": {Args: []argSpec{
+		{Name: "name", Required: true},
+	}},
+	"write": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "value"},
+	}},
+	"init_marble": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "color", Required: true},
+		{Name: "size", Required: true, Type: typeInt},
+		{Name: "user", Required: true},
+	}},
+	"set_user": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "user", Required: true},
+	}},
+	"open_trade": {
+		Args: []argSpec{
+			{Name: "user", Required: true},
+			{Name: "want_color", Required: true},
+			{Name: "want_size", Required: true, Type: typeInt},
+		},
+		Repeat: []argSpec{
+			{Name: "willing_color", Required: true},
+			{Name: "willing_size", Required: true, Type: typeInt},
+		},
+		MinRepeat: 1,
+	},
+	"perform_trade": {Args: []argSpec{
+		{Name: "id", Required: true},
+		{Name: "closer_user", Required: true},
+		{Name: "closer_marble", Required: true},
+		{Name: "opener_user", Required: true},
+		{Name: "opener_color", Required: true},
+		{Name: "opener_size", Required: true, Type: typeInt},
+	}},
+	"remove_trade": {Args: []argSpec{
+		{Name: "id", Required: true},
+	}},
+	"signup_driver": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "dl", Required: true},
+		{Name: "dob", Required: true, Format: formatDate},
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "mobile", Required: true},
+		{Name: "password", Required: true},
+		{Name: "address", Required: true},
+		{Name: "status"},
+		{Name: "modifyby"},
+		{Name: "adminemail", Format: formatEmail},
+		{Name: "rejectreason"},
+		{Name: "anycomment"},
+	}},
+	"set_status": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "name", Required: true},
+		{Name: "dl", Required: true},
+		{Name: "dob", Required: true, Format: formatDate},
+		{Name: "mobile", Required: true},
+		{Name: "password", Required: true},
+		{Name: "address", Required: true},
+		{Name: "status"},
+		{Name: "modifyby"},
+		{Name: "adminemail", Format: formatEmail},
+		{Name: "rejectreason"},
+		{Name: "anycomment"},
+	}},
+	"update_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "patch", Required: true},
+		{Name: "revision", Required: true, Type: typeInt},
+	}},
+	"review_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+	}},
+	"approve_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+	}},
+	"reject_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "reason", Required: true},
+	}},
+	"suspend_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "reason"},
+	}, Optional: 1},
+	"reinstate_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+	}},
+	"repair_records":    {},
+	"migrate_passwords": {},
+	"migrate_keys":      {},
+	"rebuild_indexes":   {},
+	"register_owners":   {},
+	"register_user": {Args: []argSpec{
+		{Name: "username", Required: true},
+	}},
+	"deactivate_user": {Args: []argSpec{
+		{Name: "username", Required: true},
+	}},
+	"book_car": {Args: []argSpec{
+		{Name: "name", Required: true},
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "class", Required: true},
+		{Name: "location", Required: true},
+		{Name: "droplocation", Required: true},
+		{Name: "pickupdate", Required: true, Format: formatISODate},
+		{Name: "pickuptime", Required: true, Format: formatTime},
+		{Name: "dropoffdate", Required: true, Format: formatISODate},
+		{Name: "dropofftime", Required: true, Format: formatTime},
+		{Name: "bookingid", Required: true},
+	}},
+}
+
+var querySchemas = map[string]argSchema{
+	"whoami": {},
+	"read": {Args: []argSpec{
+		{Name: "name", Required: true},
+	}},
+	"read_marble": {Args: []argSpec{
+		{Name: "name", Required: true},
+	}},
+	"marble_history": {Args: []argSpec{
+		{Name: "name", Required: true},
+	}},
+	"list_users": {Args: []argSpec{
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+	}, Optional: 2},
+	"list_marbles": {Args: []argSpec{
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+		{Name: "include_retired", Type: typeBool},
+	}, Optional: 3},
+	"marbles_by_owner": {Args: []argSpec{
+		{Name: "user", Required: true},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+		{Name: "include_retired", Type: typeBool},
+	}, Optional: 3},
+	"marbles_by_color": {Args: []argSpec{
+		{Name: "color", Required: true},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+		{Name: "include_retired", Type: typeBool},
+	}, Optional: 3},
+	"marbles_in_size_range": {Args: []argSpec{
+		{Name: "min_size", Required: true, Type: typeInt},
+		{Name: "max_size", Required: true, Type: typeInt},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+		{Name: "include_retired", Type: typeBool},
+	}, Optional: 3},
+	"get_booking": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "bookingid", Required: true},
+	}},
+	"list_bookings_by_driver": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+	}, Optional: 2},
+	"list_bookings_by_location": {Args: []argSpec{
+		{Name: "location", Required: true},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+	}, Optional: 2},
+	"list_bookings_by_date": {Args: []argSpec{
+		{Name: "from_date", Required: true, Format: formatISODate},
+		{Name: "to_date", Required: true, Format: formatISODate},
+		{Name: "page_size", Type: typeInt},
+		{Name: "bookmark"},
+	}, Optional: 2},
+	"read_sysadmin": {Args: []argSpec{
+		{Name: "userid", Required: true},
+	}},
+	"verify_sysadmin": {Args: []argSpec{
+		{Name: "userid", Required: true},
+		{Name: "password", Required: true},
+	}},
+	"verify_driver_credentials": {Args: []argSpec{
+		{Name: "email", Required: true, Format: formatEmail},
+		{Name: "password", Required: true},
+	}},
+}
+
+// initSchemas holds the arguments of Init, a JSON configuration object or the legacy userid and password, see setup.go
+var initSchemas = map[string]argSchema{
+	"init": {Args: []argSpec{
+		{Name: "config"},
+		{Name: "password"},
+	}, Optional: 2},
+}
+
+// ============================================================================================================================
+// checkArgs - validate args against the schema of a function, report every problem at once
+// ============================================================================================================================
+func checkArgs(schemas map[string]argSchema, function string, args []string) error {
+	schema, ok := schemas[function]
+	if !ok {
+		return nil //unknown functions are rejected by the dispatcher
+	}
+
+	var problems []Problem
+	fixed := len(schema.Args)
+	group := len(schema.Repeat)
+	switch {
+	case group == 0 && schema.Optional > 0 && (len(args) < fixed-schema.Optional || len(args) > fixed):
+		problems = append(problems, Problem{Arg: NoArg, Name: "args", Message: "expecting " + strconv.Itoa(fixed-schema.Optional) + " to " + strconv.Itoa(fixed) + " arguments, got " + strconv.Itoa(len(args))})
+	case group == 0 && schema.Optional == 0 && len(args) != fixed:
+		problems = append(problems, Problem{Arg: NoArg, Name: "args", Message: "expecting " + strconv.Itoa(fixed) + " arguments, got " + strconv.Itoa(len(args))})
+	case group > 0 && (len(args) < fixed+group*schema.MinRepeat || (len(args)-fixed)%group != 0):
+		problems = append(problems, Problem{Arg: NoArg, Name: "args", Message: "expecting " + strconv.Itoa(fixed) + " arguments followed by at least " + strconv.Itoa(schema.MinRepeat) + " groups of " + strconv.Itoa(group) + ", got " + strconv.Itoa(len(args))})
+	}
+
+	for i, value := range args {
+		var spec argSpec
+		if i < fixed {
+			spec = schema.Args[i]
+		} else if group > 0 {
+			spec = schema.Repeat[(i-fixed)%group]
+		} else {
+			break //extra args are already reported above
+		}
+		if msg := checkArg(spec, value); msg != "" {
+			problems = append(problems, Problem{Arg: i, Name: spec.Name, Message: msg})
+		}
+	}
+	for i := len(args); i < fixed; i++ { //report missing required args by name
+		if schema.Args[i].Required {
+			problems = append(problems, Problem{Arg: i, Name: schema.Args[i].Name, Message: "is required"})
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	err := newError(CodeValidation, strconv.Itoa(len(problems))+" problem(s) with the arguments of "+function)
+	err.Problems = problems
+	return err
+}
+
+// checkArg - the problem with a single value, or "" if it is fine
+func checkArg(spec argSpec, value string) string {
+	if strings.Contains(value, keySep) {
+		return "must not contain a NUL character" //it separates the parts of a key, see keys.go
+	}
+	if strings.TrimSpace(value) == "" {
+		if spec.Required {
+			return "is required"
+		}
+		return ""
+	}
+	if spec.Type == typeInt {
+		if _, err := strconv.Atoi(value); err != nil {
+			return "must be a numeric string"
+		}
+	}
+	if spec.Type == typeBool {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	}
+	switch spec.Format {
+	case formatEmail:
+		if !emailPattern.MatchString(value) {
+			return "must be an email address"
+		}
+	case formatDate:
+		if _, err := parseDate(value); err != nil {
+			return "must be a date like 2016-12-31"
+		}
+	case formatISODate:
+		if _, err := time.Parse(dateLayouts[0], strings.TrimSpace(value)); err != nil {
+			return "must be a date like 2016-12-31"
+		}
+	case formatTime:
+		if _, err := parseClock(value); err != nil {
+			return "must be a time like 17:30"
+		}
+	}
+	return ""
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// dateLayouts are the accepted spellings of a date, canonical first. Bookings only take the first, see formatISODate,
+// but dates of birth and bookings stored before that may still use the second.
+var dateLayouts = []string{"2006-01-02", "01/02/2006"}
+
+// parseDate - parse a date in any of the accepted layouts
+func parseDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var d time.Time
+		d, err = time.Parse(layout, strings.TrimSpace(value))
+		if err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// clockLayouts are the accepted spellings of a time of day, canonical first
+var clockLayouts = []string{"15:04", "15:04:05", "3:04PM", "3:04 PM"}
+
+// parseClock - parse a time of day in any of the accepted layouts
+func parseClock(value string) (time.Time, error) {
+	var err error
+	for _, layout := range clockLayouts {
+		var c time.Time
+		c, err = time.Parse(layout, strings.ToUpper(strings.TrimSpace(value)))
+		if err == nil {
+			return c, nil
+		}
+	}
+	return time.Time{}, err
+}
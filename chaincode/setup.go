@@ -0,0 +1,172 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Init runs on deploy and again on every upgrade, so it only creates what is
+// missing and never overwrites or clears anything. Emptying the ledger is
+// reset_ledger's job, an operator-only Invoke function.
+
+// LedgerConfig is the JSON object Init takes, every field is optional
+type LedgerConfig struct {
+	AdminUserid   string `json:"admin_userid"`   //sysadmin login to create, see verify_sysadmin
+	AdminPassword string `json:"admin_password"` //required with admin_userid
+	Abc           *int   `json:"abc"`            //value of the "abc" test variable, 0 when missing
+}
+
+// InitReport lists the keys Init created, keys that already existed are left as they are
+type InitReport struct {
+	Created []string `json:"created"`
+}
+
+// parseLedgerConfig - the configuration in the args of Init. They are either empty, a single JSON object, or the
+// legacy userid and password of the sysadmin.
+func parseLedgerConfig(args []string) (LedgerConfig, error) {
+	config := LedgerConfig{}
+	switch {
+	case len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == ""):
+		return config, nil
+	case len(args) == 2:
+		return LedgerConfig{AdminUserid: args[0], AdminPassword: args[1]}, nil
+	}
+	if err := json.Unmarshal([]byte(args[0]), &config); err != nil {
+		return config, argError(0, "Expecting a JSON configuration object")
+	}
+	if config.AdminUserid != "" && config.AdminPassword == "" {
+		return config, argError(0, "admin_password is required with admin_userid")
+	}
+	return config, nil
+}
+
+// ============================================================================================================================
+// initLedger - create the system keys and the sysadmin login that do not exist yet
+// ============================================================================================================================
+func (t *SimpleChaincode) initLedger(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	config, err := parseLedgerConfig(args)
+	if err != nil {
+		return nil, err
+	}
+	report := InitReport{Created: []string{}}
+
+	if config.AdminUserid != "" {
+		admin := Adminlogin{Userid: config.AdminUserid, Passwordhash: hashPassword(stub, config.AdminUserid, config.AdminPassword)}
+		created, err := putIfMissing(stub, adminKey(config.AdminUserid), func() error {
+			return putRecord(stub, adminKey(config.AdminUserid), docAdmin, &admin)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			report.Created = append(report.Created, printableKey(adminKey(config.AdminUserid)))
+		}
+	}
+
+	abc := 0
+	if config.Abc != nil {
+		abc = *config.Abc
+	}
+	emptyIndex, _ := json.Marshal([]string{})
+	emptyTrades, _ := json.Marshal(AllTrades{OpenTrades: []AnOpenTrade{}})
+	for _, system := range []struct {
+		key   string
+		value []byte
+	}{
+		{"abc", []byte(strconv.Itoa(abc))}, //test variable, handy to read/write right away to test the network
+		{driverIndexStr, emptyIndex},
+		{openTradesStr, emptyTrades},
+	} {
+		created, err := putIfMissing(stub, system.key, func() error {
+			if stub.PutState(system.key, system.value) != nil {
+				return ledgerError("Failed to write " + system.key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			report.Created = append(report.Created, system.key)
+		}
+	}
+
+	err = stub.DelState(marbleIndexStr) //drop the index stored by older versions, read builds it now
+	if err != nil {
+		return nil, ledgerError("Failed to clear marble index")
+	}
+	fmt.Println("- init created " + strconv.Itoa(len(report.Created)) + " keys")
+	return json.Marshal(report)
+}
+
+// putIfMissing - run put when nothing is stored under key, created tells whether it ran
+func putIfMissing(stub shim.ChaincodeStubInterface, key string, put func() error) (created bool, err error) {
+	valAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, ledgerError("Failed to get " + printableKey(key))
+	}
+	if len(valAsBytes) > 0 {
+		return false, nil
+	}
+	return true, put()
+}
+
+// ============================================================================================================================
+// Reset Ledger - delete every marble, trade, user, driver and booking with their indexes and history. Sysadmin logins
+// are kept so the ledger can still be administered, run migrate_keys first on a ledger that has legacy flat keys. The
+// system keys are then created again as Init would.
+// ============================================================================================================================
+func (t *SimpleChaincode) reset_ledger(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start reset ledger")
+	keysIter, err := stub.RangeQueryState("", lastKey)
+	if err != nil {
+		return nil, ledgerError("Failed to scan keys")
+	}
+	var keys []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, ledgerError("Failed to scan keys")
+		}
+		if ns, _, ok := splitKey(key); ok && ns == nsAdmin {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	keysIter.Close() //delete once the scan is done
+
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, ledgerError("Failed to delete " + printableKey(key))
+		}
+	}
+	_, err = t.initLedger(stub, nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end reset ledger, deleted " + strconv.Itoa(len(keys)) + " keys")
+	return json.Marshal(map[string]int{"deleted": len(keys)})
+}
@@ -0,0 +1,158 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+// busyLedger - a ledger with a sysadmin, users, marbles, a trade, a driver and a booking
+func busyLedger(t *testing.T) *ledgertest.MemStub {
+	s, err := ledgertest.Scenario{
+		Name:     "busy",
+		Setup:    asOperator,
+		InitArgs: []string{`{"admin_userid":"root","admin_password":"pw","abc":7}`},
+		Steps: append(registered("amy", "bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "amy"}},
+			ledgertest.Step{Function: "init_marble", Args: []string{"m2", "red", "35", "bob"}},
+			ledgertest.Step{Function: "open_trade", Args: []string{"amy", "red", "35", "blue", "16"}},
+			ledgertest.Step{Function: "delete", Args: []string{"m2"}},
+			ledgertest.Step{Function: "write", Args: []string{"abc", "9"}},
+			signedUp("d@x.com"),
+			booked("d@x.com", "b1"),
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestInitCreatesOnlyWhatIsMissing(t *testing.T) {
+	s := ledgertest.NewMemStub()
+	s.State[marbleIndexStr] = []byte(`["m1"]`) //stored by older versions
+	res, err := s.Init(new(SimpleChaincode), "init", []string{`{"admin_userid":"root","admin_password":"pw","abc":7}`})
+	want := `{"created":["` + printableKey(adminKey("root")) + `","abc","_driverindex","_opentrades"]}`
+	if err != nil || !ledgertest.Equal(res, []byte(want)) {
+		t.Fatalf("init: %s %v, want %s", res, err, want)
+	}
+	err = ledgertest.CheckState(s, map[string]string{
+		"abc":          "7",
+		driverIndexStr: `[]`,
+		openTradesStr:  `{"open_trades":[]}`,
+		marbleIndexStr: "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitAgainChangesNothing(t *testing.T) {
+	s := busyLedger(t)
+	before := map[string]string{}
+	for _, key := range s.Keys() {
+		before[key] = string(s.Get(key))
+	}
+	for _, args := range [][]string{
+		{`{"admin_userid":"root","admin_password":"pw","abc":7}`},
+		{`{"admin_userid":"root","admin_password":"changed","abc":1}`},
+		{"root", "changed"}, //the legacy userid and password
+		{""},
+		{},
+	} {
+		res, err := s.Init(new(SimpleChaincode), "init", args)
+		if err != nil || !ledgertest.Equal(res, []byte(`{"created":[]}`)) {
+			t.Errorf("init %v: %s %v", args, res, err)
+		}
+		if err := ledgertest.CheckState(s, before); err != nil || len(s.Keys()) != len(before) {
+			t.Fatalf("init %v changed the ledger: %v", args, err)
+		}
+	}
+	if res, _ := s.Query(new(SimpleChaincode), "verify_sysadmin", []string{"root", "pw"}); string(res) != match {
+		t.Errorf("the sysadmin password changed: %s", res)
+	}
+}
+
+func TestParseLedgerConfig(t *testing.T) {
+	seven := 7
+	for _, tc := range []struct {
+		args []string
+		want LedgerConfig
+		ok   bool
+	}{
+		{nil, LedgerConfig{}, true},
+		{[]string{" "}, LedgerConfig{}, true},
+		{[]string{"root", "pw"}, LedgerConfig{AdminUserid: "root", AdminPassword: "pw"}, true},
+		{[]string{`{"abc":7}`}, LedgerConfig{Abc: &seven}, true},
+		{[]string{`{"admin_userid":"root","admin_password":"pw"}`}, LedgerConfig{AdminUserid: "root", AdminPassword: "pw"}, true},
+		{[]string{`{"admin_userid":"root"}`}, LedgerConfig{}, false},
+		{[]string{`99`}, LedgerConfig{}, false},
+		{[]string{`{"abc":"seven"}`}, LedgerConfig{}, false},
+	} {
+		got, err := parseLedgerConfig(tc.args)
+		if tc.ok && (err != nil || !reflect.DeepEqual(got, tc.want)) {
+			t.Errorf("%q: got %+v %v, want %+v", tc.args, got, err, tc.want)
+		}
+		if !tc.ok && !hasCode(err, CodeInvalidArg) {
+			t.Errorf("%q: got %v, want %s", tc.args, err, CodeInvalidArg)
+		}
+	}
+}
+
+func TestResetLedger(t *testing.T) {
+	s := busyLedger(t)
+	cc := new(SimpleChaincode)
+	if _, err := s.Invoke(cc, "reset_ledger", nil); !hasCode(err, CodeNotAllowed) {
+		t.Fatalf("reset_ledger by a rental admin: %v", err) //busyLedger leaves the driver as the caller
+	}
+	asOperator(s)
+	kept := len(s.Keys())
+	res, err := s.Invoke(cc, "reset_ledger", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted map[string]int
+	json.Unmarshal(res, &deleted)
+
+	keys := s.Keys()
+	sort.Strings(keys)
+	want := []string{adminKey("root"), driverIndexStr, openTradesStr, "abc"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(keys, want) || deleted["deleted"] != kept-1 {
+		t.Fatalf("after reset %q, deleted %s of %d keys", keys, res, kept)
+	}
+	err = ledgertest.CheckState(s, map[string]string{
+		"abc":          "0",
+		driverIndexStr: `[]`,
+		openTradesStr:  `{"open_trades":[]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := s.Query(cc, "verify_sysadmin", []string{"root", "pw"}); string(res) != match {
+		t.Errorf("the sysadmin is gone: %s", res)
+	}
+	if res, _ := s.Query(cc, "list_marbles", nil); !ledgertest.Equal(res, []byte(`{"marbles":[],"bookmark":""}`)) {
+		t.Errorf("marbles after reset: %s", res)
+	}
+}
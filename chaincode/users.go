@@ -0,0 +1,229 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Marbles can only be owned by registered, active users. Usernames are
+// stored lower case, the same as marble owners.
+const (
+	nsUser  = "user"
+	docUser = "user"
+)
+
+// User is someone who can own and trade marbles
+type User struct {
+	Record
+	Username    string `json:"username"`
+	Active      bool   `json:"active"`
+	Registered  int64  `json:"registered"`            //utc timestamp of registration, in milliseconds
+	Deactivated int64  `json:"deactivated,omitempty"` //utc timestamp of deactivation, in milliseconds
+}
+
+// UserPage is one page of list_users, see MarblePage
+type UserPage struct {
+	Users    []User `json:"users"`
+	Bookmark string `json:"bookmark"`
+}
+
+func userKey(username string) string {
+	return makeKey(nsUser, strings.ToLower(username))
+}
+
+// ============================================================================================================================
+// requireActiveUser - error unless username, args[i], is a registered user who has not been deactivated
+// ============================================================================================================================
+func requireActiveUser(stub shim.ChaincodeStubInterface, username string, i int) error {
+	user := User{}
+	found, err := getRecord(stub, userKey(username), docUser, &user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &ChaincodeError{Code: CodeNotFound, Message: "User " + username + " is not registered", Arg: i}
+	}
+	if !user.Active {
+		return &ChaincodeError{Code: CodeNotAllowed, Message: "User " + username + " has been deactivated", Arg: i}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Register User - create a user who can own marbles
+// ============================================================================================================================
+func (t *SimpleChaincode) register_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0
+	// "username"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	fmt.Println("- start register user")
+	username := strings.ToLower(args[0])
+
+	existing := User{}
+	found, err := getRecord(stub, userKey(username), docUser, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, &ChaincodeError{Code: CodeAlreadyExists, Message: "User " + username + " is already registered", Arg: 0}
+	}
+
+	user, err := t.newUser(stub, username)
+	if err != nil {
+		return nil, err
+	}
+	err = putRecord(stub, userKey(username), docUser, &user)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end register user")
+	return nil, emitEvent(stub, eventUserRegistered, UserEvent{User: user})
+}
+
+func (t *SimpleChaincode) newUser(stub shim.ChaincodeStubInterface, username string) (User, error) {
+	registered, err := t.makeTimestamp(stub)
+	if err != nil {
+		return User{}, err
+	}
+	return User{Username: username, Active: true, Registered: registered}, nil
+}
+
+// ============================================================================================================================
+// Deactivate User - stop a user from receiving marbles, the marbles they own stay theirs
+// ============================================================================================================================
+func (t *SimpleChaincode) deactivate_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0
+	// "username"
+	if len(args) != 1 {
+		return nil, argCountError("1")
+	}
+	fmt.Println("- start deactivate user")
+	user := User{}
+	found, err := getRecord(stub, userKey(args[0]), docUser, &user)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &ChaincodeError{Code: CodeNotFound, Message: "User " + args[0] + " is not registered", Arg: 0}
+	}
+	if !user.Active {
+		return nil, &ChaincodeError{Code: CodeNotAllowed, Message: "User " + args[0] + " is already deactivated", Arg: 0}
+	}
+
+	user.Active = false
+	user.Deactivated, err = t.makeTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = putRecord(stub, userKey(args[0]), docUser, &user)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("- end deactivate user")
+	return nil, emitEvent(stub, eventUserDeactivated, UserEvent{User: user})
+}
+
+// ============================================================================================================================
+// List Users - query, one page of users in username order, deactivated users included
+// ============================================================================================================================
+func (t *SimpleChaincode) list_users(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1
+	// "page_size", "bookmark"     both optional, bookmark is the one returned with the previous page
+	limit, err := pageSize(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, end := namespaceRange(nsUser)
+	if len(args) > 1 && args[1] != "" {
+		start = userKey(args[1])
+	}
+	keysIter, err := stub.RangeQueryState(start, end)
+	if err != nil {
+		return nil, ledgerError("Failed to scan users")
+	}
+	defer keysIter.Close()
+
+	page := UserPage{Users: []User{}}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, ledgerError("Failed to scan users")
+		}
+		user := User{}
+		if json.Unmarshal(valAsBytes, &user) != nil {
+			fmt.Println("! skipping corrupt user " + printableKey(key))
+			continue
+		}
+		if len(page.Users) == limit {
+			page.Bookmark = user.Username //first user of the next page
+			break
+		}
+		page.Users = append(page.Users, user)
+	}
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// Register Owners - register every owner of an existing marble, for ledgers written before users had to be registered
+// ============================================================================================================================
+func (t *SimpleChaincode) register_owners(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("- start register owners")
+	var owners []string
+	seen := map[string]bool{}
+	err := scanMarbles(stub, "", func(marble Marble) bool {
+		owner := strings.ToLower(marble.User)
+		if owner != "" && !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	registered := []string{}
+	for _, owner := range owners {
+		valAsBytes, err := stub.GetState(userKey(owner))
+		if err != nil {
+			return nil, ledgerError("Failed to get user " + owner)
+		}
+		if len(valAsBytes) > 0 {
+			continue //registered, or deactivated on purpose
+		}
+		user, err := t.newUser(stub, owner)
+		if err != nil {
+			return nil, err
+		}
+		err = putRecord(stub, userKey(owner), docUser, &user)
+		if err != nil {
+			return nil, err
+		}
+		registered = append(registered, owner)
+	}
+	fmt.Println("- end register owners, registered " + strconv.Itoa(len(registered)))
+	return json.Marshal(map[string][]string{"registered": registered})
+}
@@ -0,0 +1,70 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ibm-blockchain/marbles/chaincode/ledgertest"
+)
+
+func TestOwnersAreStoredLowerCase(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name:     "owners",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob", "leroy"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "Leroy"}},
+			ledgertest.Step{Function: "set_user", Args: []string{"m1", "BOB"}},
+			ledgertest.Step{Function: "marble_history", Args: []string{"m1"}, Query: true, Check: func(s *ledgertest.MemStub, res []byte) error {
+				var history []HistoryEntry
+				json.Unmarshal(res, &history)
+				if len(history) != 2 || history[0].To != "leroy" || history[1].From != "leroy" || history[1].To != "bob" {
+					return fmt.Errorf("history: %s", res)
+				}
+				return nil
+			}},
+		),
+		State: map[string]string{
+			marbleKey("m1"): marbleState("m1", "blue", 16, "bob"),
+		},
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreNeedsAnActiveOwner(t *testing.T) {
+	_, err := ledgertest.Scenario{
+		Name:     "restore",
+		Setup:    asOperator,
+		InitArgs: []string{},
+		Steps: append(registered("bob"),
+			ledgertest.Step{Function: "init_marble", Args: []string{"m1", "blue", "16", "bob"}},
+			ledgertest.Step{Function: "delete_marble", Args: []string{"m1", "lost"}},
+			ledgertest.Step{Function: "deactivate_user", Args: []string{"bob"}},
+			ledgertest.Step{Function: "restore_marble", Args: []string{"m1"}, WantErr: `"code":"NOT_ALLOWED"`},
+			ledgertest.Step{Function: "set_user", Args: []string{"m1", "bob"}, WantErr: `"code":"NOT_ALLOWED"`},
+		),
+	}.Run(new(SimpleChaincode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
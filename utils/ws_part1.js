@@ -31,7 +31,7 @@ module.exports.process_msg = function(ws, data){
 		else if(data.type == 'remove'){
 			console.log('removing msg');
 			if(data.name){
-				chaincode.invoke.delete([data.name]);
+				chaincode.invoke.delete_marble([data.name]);
 			}
 		}
 		else if(data.type == 'chainstats'){
@@ -52,7 +52,7 @@ module.exports.process_msg = function(ws, data){
 				//serialized version
 				async.eachLimit(keys, concurrency, function(key, cb) {
 					console.log('!', json[key]);
-					chaincode.query.read([json[key]], function(e, marble) {
+					chaincode.query.read_marble([json[key]], function(e, marble) {
 						if(e != null) console.log('[ws error] did not get marble:', e);
 						else {
 							if(marble) sendMsg({msg: 'marbles', e: e, marble: JSON.parse(marble)});
@@ -20,7 +20,7 @@ module.exports.process_msg = function(ws, data){
 		}
 		else if(data.type == 'get'){
 			console.log('get marbles msg');
-			chaincode.query.read(['_marbleindex'], cb_got_index);
+			chaincode.query.list_marbles(['', ''], cb_got_page);
 		}
 		else if(data.type == 'transfer'){
 			console.log('transfering msg');
@@ -31,7 +31,7 @@ module.exports.process_msg = function(ws, data){
 		else if(data.type == 'remove'){
 			console.log('removing msg');
 			if(data.name){
-				chaincode.invoke.delete([data.name]);
+				chaincode.invoke.delete_marble([data.name]);
 			}
 		}
 		else if(data.type == 'chainstats'){
@@ -70,16 +70,16 @@ module.exports.process_msg = function(ws, data){
 	}
 	
 	
-	//got the marble index, lets get each marble
-	function cb_got_index(e, index){
-		if(e != null) console.log('[ws error] did not get marble index:', e);
+	//got a page of marbles, send each one and ask for the next page
+	function cb_got_page(e, page){
+		if(e != null) console.log('[ws error] did not get marbles:', cc_error(e));
 		else{
 			try{
-				var json = JSON.parse(index);
-				for(var i in json){
-					console.log('!', i, json[i]);
-					chaincode.query.read([json[i]], cb_got_marble);												//iter over each, read their values
+				var json = JSON.parse(page);
+				for(var i in json.marbles){
+					sendMsg({msg: 'marbles', marble: json.marbles[i]});
 				}
+				if(json.bookmark) chaincode.query.list_marbles(['', json.bookmark], cb_got_page);				//more to come
 			}
 			catch(e){
 				console.log('[ws error] could not parse response', e);
@@ -87,19 +87,12 @@ module.exports.process_msg = function(ws, data){
 		}
 	}
 	
-	//call back for getting a marble, lets send a message
-	function cb_got_marble(e, marble){
-		if(e != null) console.log('[ws error] did not get marble:', e);
-		else {
-			try{
-				sendMsg({msg: 'marbles', marble: JSON.parse(marble)});
-			}
-			catch(e){}
-		}
-	}
-	
 	function cb_invoked(e, a){
 		console.log('response: ', e, a);
+		if(e != null){
+			var err = cc_error(e);
+			sendMsg({msg: 'error', code: err.code, e: err});
+		}
 	}
 	
 	//call back for getting the blockchain stats, lets get the block stats now
@@ -127,7 +120,7 @@ module.exports.process_msg = function(ws, data){
 	
 	//call back for getting open trades, lets send the trades
 	function cb_got_trades(e, trades){
-		if(e != null) console.log('[ws error] did not get open trades:', e);
+		if(e != null) console.log('[ws error] did not get open trades:', cc_error(e));
 		else {
 			try{
 				trades = JSON.parse(trades);
@@ -139,6 +132,20 @@ module.exports.process_msg = function(ws, data){
 		}
 	}
 
+	//chaincode errors are json with a stable "code" field, dig it out of the sdk's error
+	function cc_error(e){
+		var details = (e && e.details) ? e.details : e;
+		if(typeof details !== 'string') details = JSON.stringify(details);
+		var pos = details ? details.indexOf('{"code"') : -1;
+		if(pos >= 0){
+			try{
+				return JSON.parse(details.substring(pos, details.lastIndexOf('}') + 1));
+			}
+			catch(err){}
+		}
+		return {code: 'UNKNOWN', message: details};
+	}
+
 	//send a message, socket might be closed...
 	function sendMsg(json){
 		if(ws){